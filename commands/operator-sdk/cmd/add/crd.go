@@ -28,6 +28,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	statusSubresource bool
+	servedVersions    []string
+)
+
 // NewAddCrdCmd - add crd command
 func NewAddCrdCmd() *cobra.Command {
 	crdCmd := &cobra.Command{
@@ -47,6 +52,8 @@ Generated CR  filename: <project-name>/deploy/crds/<group>_<version>_<kind>_cr.y
 	crdCmd.MarkFlagRequired("api-version")
 	crdCmd.Flags().StringVar(&kind, "kind", "", "Kubernetes CustomResourceDefintion kind. (e.g AppService)")
 	crdCmd.MarkFlagRequired("kind")
+	crdCmd.Flags().BoolVar(&statusSubresource, "status-subresource", false, "Enable the status subresource in the generated CRD")
+	crdCmd.Flags().StringSliceVar(&servedVersions, "served-versions", nil, "Versions served by the generated CRD next to the storage version of --api-version (e.g v1alpha1)")
 	return crdCmd
 }
 
@@ -69,7 +76,7 @@ func crdFunc(cmd *cobra.Command, args []string) {
 	}
 	s := scaffold.Scaffold{}
 	err = s.Execute(cfg,
-		&scaffold.Crd{Resource: resource},
+		&scaffold.Crd{Resource: resource, StatusSubresource: statusSubresource, ServedVersions: servedVersions},
 		&scaffold.Cr{Resource: resource},
 	)
 
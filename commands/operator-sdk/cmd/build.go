@@ -26,6 +26,7 @@ import (
 
 	"github.com/operator-framework/operator-sdk/internal/util/projutil"
 	"github.com/operator-framework/operator-sdk/pkg/scaffold"
+	"github.com/operator-framework/operator-sdk/pkg/scaffold/ansible"
 	"github.com/operator-framework/operator-sdk/pkg/scaffold/input"
 	"github.com/operator-framework/operator-sdk/pkg/test"
 
@@ -157,6 +158,12 @@ func buildFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintln(os.Stdout, string(o))
 	}
 
+	// The roles and collections of an Ansible Operator are installed by its
+	// Dockerfile, which older projects do not do.
+	if !mainExists() {
+		verifyRequirementsInstalled()
+	}
+
 	image := args[0]
 	baseImageName := image
 	if enableTests {
@@ -216,6 +223,22 @@ func buildFunc(cmd *cobra.Command, args []string) {
 	}
 }
 
+// verifyRequirementsInstalled warns when the project has a requirements.yml
+// that the Dockerfile does not install with ansible-galaxy.
+func verifyRequirementsInstalled() {
+	if _, err := os.Stat(ansible.RequirementsYamlFile); err != nil {
+		return
+	}
+	dockerfile, err := ioutil.ReadFile(filepath.Join(scaffold.BuildDir, scaffold.DockerfileFile))
+	if err != nil {
+		log.Fatalf("could not read Dockerfile: %v", err)
+	}
+	if !bytes.Contains(dockerfile, []byte(ansible.RequirementsYamlFile)) {
+		fmt.Printf("WARNING: %s is not installed by %s, add:\n%s\n", ansible.RequirementsYamlFile,
+			filepath.Join(scaffold.BuildDir, scaffold.DockerfileFile), ansible.RequirementsInstallInstructions)
+	}
+}
+
 func mainExists() bool {
 	_, err := os.Stat(filepath.Join(scaffold.ManagerDir, scaffold.CmdFile))
 	return err == nil
@@ -180,7 +180,8 @@ func doAnsibleScaffold() {
 		&scaffold.RoleBinding{},
 		&ansible.Operator{},
 		&scaffold.Crd{
-			Resource: resource,
+			Resource:          resource,
+			StatusSubresource: true,
 		},
 		&scaffold.Cr{
 			Resource: resource,
@@ -30,6 +30,10 @@ import (
 	"github.com/operator-framework/operator-sdk/internal/util/projutil"
 	ansibleOperator "github.com/operator-framework/operator-sdk/pkg/ansible/operator"
 	proxy "github.com/operator-framework/operator-sdk/pkg/ansible/proxy"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/artifacts"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"github.com/operator-framework/operator-sdk/pkg/scaffold"
 	ansibleScaffold "github.com/operator-framework/operator-sdk/pkg/scaffold/ansible"
@@ -55,6 +59,7 @@ kubernetes cluster using a kubeconfig file.
 	upLocalCmd.Flags().StringVar(&kubeConfig, "kubeconfig", "", "The file path to kubernetes configuration file; defaults to $HOME/.kube/config")
 	upLocalCmd.Flags().StringVar(&operatorFlags, "operator-flags", "", "The flags that the operator needs. Example: \"--flag1 value1 --flag2=value2\"")
 	upLocalCmd.Flags().StringVar(&namespace, "namespace", "default", "The namespace where the operator watches for changes.")
+	upLocalCmd.Flags().IntVar(&maxWorkers, "max-workers", ansibleOperator.DefaultMaxWorkers(), "Ansible operator only: the default number of resources of each watched kind reconciled concurrently, unless maxWorkers is set in watches.yaml. Defaults to $"+ansibleOperator.MaxWorkersEnvVar+" or 1")
 
 	return upLocalCmd
 }
@@ -63,6 +68,7 @@ var (
 	kubeConfig    string
 	operatorFlags string
 	namespace     string
+	maxWorkers    int
 )
 
 const (
@@ -135,18 +141,36 @@ func upLocalAnsible() {
 	logrus.Infof("watching namespace: %s", namespace)
 	done := make(chan error)
 
+	cMap := controllermap.NewControllerMap()
+	tokens := kubeconfig.NewTokens()
+
 	// start the proxy
-	err = proxy.Run(done, proxy.Options{
-		Address:    "localhost",
-		Port:       8888,
-		KubeConfig: mgr.GetConfig(),
+	proxyURL, err := proxy.Run(done, proxy.Options{
+		Address:        "localhost",
+		Port:           0,
+		KubeConfig:     mgr.GetConfig(),
+		ControllerMap:  cMap,
+		RESTMapper:     mgr.GetRESTMapper(),
+		Cache:          mgr.GetCache(),
+		WatchNamespace: namespace,
+		Tokens:         tokens,
 	})
 	if err != nil {
 		logrus.Fatalf("error starting proxy: %v", err)
 	}
 
+	// serve the artifacts of the runs
+	err = artifacts.Serve(done, artifacts.Options{
+		Address: "localhost",
+		Port:    artifacts.DefaultPort,
+		Dir:     runner.InputDirRoot,
+	})
+	if err != nil {
+		logrus.Errorf("error serving runner artifacts: %v", err)
+	}
+
 	// start the operator
-	go ansibleOperator.Run(done, mgr, "./"+ansibleScaffold.WatchesYamlFile, time.Minute)
+	go ansibleOperator.Run(done, mgr, "./"+ansibleScaffold.WatchesYamlFile, time.Minute, maxWorkers, false, cMap, tokens, proxyURL)
 
 	// wait for either to finish
 	err = <-done
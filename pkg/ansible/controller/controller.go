@@ -21,18 +21,28 @@ import (
 	"time"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
 
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// defaultProxyURL - the URL of the proxy when it is not set in the Options.
+const defaultProxyURL = "http://localhost:8888"
+
 // Options - options for your controller
 type Options struct {
 	EventHandlers   []events.EventHandler
@@ -40,25 +50,81 @@ type Options struct {
 	Runner          runner.Runner
 	GVK             schema.GroupVersionKind
 	ReconcilePeriod time.Duration
+	SkipUnchanged   bool
+	// ChangedOwners - the resources enqueued because one of their dependent
+	// resources changed, which SkipUnchanged does not skip. Optional.
+	ChangedOwners *handler.ChangedOwners
+	// MaxWorkers - number of resources reconciled concurrently. Each worker
+	// runs at most one ansible-runner process at a time.
+	MaxWorkers int
+	// KubernetesEvents - record the failed tasks and the summary of the runs
+	// as Kubernetes Events on the resource, and KubernetesDebugEvents the
+	// debug messages as well.
+	KubernetesEvents      bool
+	KubernetesDebugEvents bool
+	// Selector - selects the resources reconciled, every resource of the GVK
+	// when it is nil.
+	Selector labels.Selector
+	// Tokens - the tokens authenticating the runs to the proxy, shared with
+	// the proxy.
+	Tokens *kubeconfig.Tokens
+	// ProxyURL - the URL of the proxy, as returned by proxy.Run, written in
+	// the kubeconfig of the runs. Defaults to http://localhost:8888.
+	ProxyURL string
 }
 
 // Add - Creates a new ansible operator controller and adds it to the manager
-func Add(mgr manager.Manager, options Options) {
+func Add(mgr manager.Manager, options Options) controller.Controller {
 	logrus.Infof("Watching %s/%v, %s", options.GVK.Group, options.GVK.Version, options.GVK.Kind)
 	if options.EventHandlers == nil {
 		options.EventHandlers = []events.EventHandler{}
 	}
 	eventHandlers := append(options.EventHandlers, events.NewLoggingEventHandler(options.LoggingLevel))
+	controllerName := fmt.Sprintf("%v-controller", strings.ToLower(options.GVK.Kind))
+	if options.KubernetesEvents {
+		eventHandlers = append(eventHandlers, events.NewKubernetesEventHandler(mgr.GetRecorder(controllerName), options.KubernetesDebugEvents))
+	}
 	if options.ReconcilePeriod == time.Duration(0) {
 		options.ReconcilePeriod = time.Minute
 	}
+	if options.MaxWorkers <= 0 {
+		options.MaxWorkers = 1
+	}
+	if options.Selector == nil {
+		options.Selector = labels.Everything()
+	}
+	if options.Tokens == nil {
+		options.Tokens = kubeconfig.NewTokens()
+	}
+	if options.ProxyURL == "" {
+		options.ProxyURL = defaultProxyURL
+	}
+
+	statusSubresource, err := hasStatusSubresource(mgr.GetConfig(), options.GVK)
+	if err != nil {
+		logrus.Warnf("unable to determine if %v has the status subresource enabled, assuming it does not: %v", options.GVK, err)
+	}
+
+	apiClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		logrus.Warnf("unable to create a client for the API, the dependent resources of %v owned through annotations will be listed from the cache: %v", options.GVK, err)
+		apiClient = mgr.GetClient()
+	}
 
 	aor := &AnsibleOperatorReconciler{
-		Client:          mgr.GetClient(),
-		GVK:             options.GVK,
-		Runner:          options.Runner,
-		EventHandlers:   eventHandlers,
-		ReconcilePeriod: options.ReconcilePeriod,
+		Client:            mgr.GetClient(),
+		GVK:               options.GVK,
+		Runner:            options.Runner,
+		EventHandlers:     eventHandlers,
+		Dispatcher:        events.NewDispatcher(metrics.GVKLabel(options.GVK), eventHandlers, events.DefaultQueueSize),
+		ReconcilePeriod:   options.ReconcilePeriod,
+		StatusSubresource: statusSubresource,
+		SkipUnchanged:     options.SkipUnchanged,
+		ChangedOwners:     options.ChangedOwners,
+		Selector:          options.Selector,
+		Tokens:            options.Tokens,
+		ProxyURL:          options.ProxyURL,
+		APIClient:         apiClient,
 	}
 
 	// Register the GVK with the schema
@@ -69,15 +135,46 @@ func Add(mgr manager.Manager, options Options) {
 	})
 
 	//Create new controller runtime controller and set the controller to watch GVK.
-	c, err := controller.New(fmt.Sprintf("%v-controller", strings.ToLower(options.GVK.Kind)), mgr, controller.Options{
-		Reconciler: aor,
+	c, err := controller.New(controllerName, mgr, controller.Options{
+		Reconciler:              aor,
+		MaxConcurrentReconciles: options.MaxWorkers,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	u := &unstructured.Unstructured{}
 	u.SetGroupVersionKind(options.GVK)
-	if err := c.Watch(&source.Kind{Type: u}, &crthandler.EnqueueRequestForObject{}); err != nil {
+	if err := c.Watch(&source.Kind{Type: u}, &crthandler.EnqueueRequestForObject{}, newPredicate(options.Selector)); err != nil {
 		log.Fatal(err)
 	}
+	return c
+}
+
+// hasStatusSubresource - uses discovery to check if the status subresource is
+// enabled for the resource of the GVK.
+func hasStatusSubresource(cfg *rest.Config, gvk schema.GroupVersionKind) (bool, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return false, err
+	}
+	resources, err := dc.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err != nil {
+		return false, err
+	}
+	resourceName := ""
+	for _, r := range resources.APIResources {
+		if r.Kind == gvk.Kind && !strings.Contains(r.Name, "/") {
+			resourceName = r.Name
+			break
+		}
+	}
+	if resourceName == "" {
+		return false, fmt.Errorf("resource for kind %s was not found", gvk.Kind)
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == resourceName+"/status" {
+			return true, nil
+		}
+	}
+	return false, nil
 }
@@ -0,0 +1,193 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DependentsAnnotation - annotation holding the kinds and namespaces of
+	// the dependent resources of a CR owned through the annotations of the
+	// handler package, which are not garbage collected by Kubernetes.
+	DependentsAnnotation = "ansible.operator-sdk/annotated-dependents"
+
+	// DependentsFinalizer - finalizer added to the CRs with dependent
+	// resources owned through annotations, removed once they are deleted.
+	DependentsFinalizer = "ansible.operator-sdk/annotated-dependents"
+)
+
+// annotatedDependents - returns the dependent resources recorded in the
+// DependentsAnnotation of u.
+func annotatedDependents(u *unstructured.Unstructured) []kubeconfig.Dependent {
+	v, ok := u.GetAnnotations()[DependentsAnnotation]
+	if !ok {
+		return nil
+	}
+	dependents := []kubeconfig.Dependent{}
+	if err := json.Unmarshal([]byte(v), &dependents); err != nil {
+		logrus.Errorf("ignoring the invalid %s annotation of %s/%s: %v", DependentsAnnotation, u.GetNamespace(), u.GetName(), err)
+		return nil
+	}
+	return dependents
+}
+
+// mergeDependents - adds the dependents of added missing from u to its
+// DependentsAnnotation, and returns true if the annotation changed.
+func mergeDependents(u *unstructured.Unstructured, added []kubeconfig.Dependent) (bool, error) {
+	dependents := annotatedDependents(u)
+	changed := false
+	for _, d := range added {
+		if !containsDependent(dependents, d) {
+			dependents = append(dependents, d)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	v, err := json.Marshal(dependents)
+	if err != nil {
+		return false, err
+	}
+	a := u.GetAnnotations()
+	if a == nil {
+		a = map[string]string{}
+	}
+	a[DependentsAnnotation] = string(v)
+	u.SetAnnotations(a)
+	return true, nil
+}
+
+func containsDependent(l []kubeconfig.Dependent, d kubeconfig.Dependent) bool {
+	for _, elem := range l {
+		if elem == d {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAnnotatedDependents - records the dependent resources annotated by a
+// run in the DependentsAnnotation of u and adds the DependentsFinalizer, so
+// that they are deleted with u even if the operator restarts in between. The
+// finalizer cannot be added to a CR being deleted, whose new dependent
+// resources are deleted right away instead.
+func (r *AnsibleOperatorReconciler) recordAnnotatedDependents(u *unstructured.Unstructured, added []kubeconfig.Dependent) error {
+	if len(added) == 0 {
+		return nil
+	}
+	if u.GetDeletionTimestamp() != nil && !contains(u.GetFinalizers(), DependentsFinalizer) {
+		return r.deleteDependents(u, added)
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		changed, err := mergeDependents(u, added)
+		if err != nil {
+			return err
+		}
+		if !contains(u.GetFinalizers(), DependentsFinalizer) {
+			u.SetFinalizers(append(u.GetFinalizers(), DependentsFinalizer))
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		err = r.Client.Update(context.TODO(), u)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		logrus.Debugf("conflict recording the dependent resources of %s/%s, retrying", u.GetNamespace(), u.GetName())
+		latest := &unstructured.Unstructured{}
+		latest.SetGroupVersionKind(r.GVK)
+		getErr := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}, latest)
+		if getErr != nil {
+			return getErr
+		}
+		u.Object = latest.Object
+		return err
+	})
+}
+
+// deleteAnnotatedDependents - deletes the dependent resources recorded in the
+// DependentsAnnotation of u, which is being deleted, and removes the
+// DependentsFinalizer.
+func (r *AnsibleOperatorReconciler) deleteAnnotatedDependents(u *unstructured.Unstructured) error {
+	if err := r.deleteDependents(u, annotatedDependents(u)); err != nil {
+		return err
+	}
+	return r.removeFinalizer(u, DependentsFinalizer)
+}
+
+// deleteDependents - deletes the resources of the kinds and namespaces of
+// dependents annotated with u. The kinds that the operator is not allowed to
+// list or delete, or that no longer exist, are skipped, since retrying would
+// not help.
+func (r *AnsibleOperatorReconciler) deleteDependents(u *unstructured.Unstructured, dependents []kubeconfig.Dependent) error {
+	c := r.APIClient
+	if c == nil {
+		c = r.Client
+	}
+	owner := types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}
+	for _, d := range dependents {
+		l := &unstructured.UnstructuredList{}
+		l.SetGroupVersionKind(schema.FromAPIVersionAndKind(d.APIVersion, d.Kind))
+		err := c.List(context.TODO(), &client.ListOptions{Namespace: d.Namespace}, l)
+		if skipDependentError(err) {
+			logrus.Errorf("unable to list the %s dependent resources of %s/%s in %q, skipping them: %v", d.Kind, u.GetNamespace(), u.GetName(), d.Namespace, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i := range l.Items {
+			dependent := &l.Items[i]
+			if o, ok := handler.OwnerFromAnnotations(dependent, r.GVK.GroupKind()); !ok || o != owner {
+				continue
+			}
+			logrus.Infof("deleting %s %s/%s owned by %s %s", dependent.GetKind(), dependent.GetNamespace(), dependent.GetName(), r.GVK.Kind, owner)
+			err := c.Delete(context.TODO(), dependent)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if skipDependentError(err) {
+				logrus.Errorf("unable to delete %s %s/%s, skipping it: %v", dependent.GetKind(), dependent.GetNamespace(), dependent.GetName(), err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipDependentError - returns true for the errors that retrying the cleanup
+// of the dependent resources would not fix.
+func skipDependentError(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || meta.IsNoMatchError(err)
+}
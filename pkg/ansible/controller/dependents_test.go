@@ -0,0 +1,82 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMergeDependents(t *testing.T) {
+	configMaps := kubeconfig.Dependent{APIVersion: "v1", Kind: "ConfigMap", Namespace: "other"}
+	namespaces := kubeconfig.Dependent{APIVersion: "v1", Kind: "Namespace"}
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		added       []kubeconfig.Dependent
+		changed     bool
+		expected    []kubeconfig.Dependent
+	}{
+		{
+			name:     "first dependents",
+			added:    []kubeconfig.Dependent{configMaps, namespaces},
+			changed:  true,
+			expected: []kubeconfig.Dependent{configMaps, namespaces},
+		},
+		{
+			name:        "known dependents",
+			annotations: map[string]string{DependentsAnnotation: `[{"apiVersion":"v1","kind":"ConfigMap","namespace":"other"}]`},
+			added:       []kubeconfig.Dependent{configMaps},
+			expected:    []kubeconfig.Dependent{configMaps},
+		},
+		{
+			name:        "new dependents",
+			annotations: map[string]string{DependentsAnnotation: `[{"apiVersion":"v1","kind":"ConfigMap","namespace":"other"}]`, "app": "memcached"},
+			added:       []kubeconfig.Dependent{namespaces, configMaps},
+			changed:     true,
+			expected:    []kubeconfig.Dependent{configMaps, namespaces},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{DependentsAnnotation: `{`},
+			added:       []kubeconfig.Dependent{namespaces},
+			changed:     true,
+			expected:    []kubeconfig.Dependent{namespaces},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetAnnotations(tc.annotations)
+			changed, err := mergeDependents(u, tc.added)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != tc.changed {
+				t.Fatalf("expected changed to be %v, got: %v", tc.changed, changed)
+			}
+			if dependents := annotatedDependents(u); !reflect.DeepEqual(dependents, tc.expected) {
+				t.Fatalf("expected the dependents %v, got: %v", tc.expected, dependents)
+			}
+			if v, ok := tc.annotations["app"]; ok && u.GetAnnotations()["app"] != v {
+				t.Fatalf("the other annotations were not kept: %v", u.GetAnnotations())
+			}
+		})
+	}
+}
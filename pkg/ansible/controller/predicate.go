@@ -0,0 +1,90 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// PausedAnnotation - annotation used by a user to pause the reconciliation of
+// a CR. To use set the annotation "ansible.operator-sdk/paused: true" on the
+// CR, the operator then only sets the Paused condition in its status until
+// the annotation is removed.
+const PausedAnnotation = "ansible.operator-sdk/paused"
+
+// isPaused - whether the reconciliation of the object is paused.
+func isPaused(m metav1.Object) bool {
+	return m.GetAnnotations()[PausedAnnotation] == "true"
+}
+
+// newPredicate - filters the events of the CRs, keeping the ones of the CRs
+// matched by selector. The updates of paused CRs are dropped, except the ones
+// changing their annotations or deleting them, so that unpausing or forcing
+// the removal of the finalizer is seen. The updates of CRs being deleted are
+// dropped when they leave the spec, the annotations and the deletion timestamp
+// unchanged, so that writing the status after a failed finalizer run does not
+// run the finalizer again before its retry delay.
+func newPredicate(selector labels.Selector) predicate.Predicate {
+	selected := func(m metav1.Object) bool {
+		return m != nil && selector.Matches(labels.Set(m.GetLabels()))
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return selected(e.Meta)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return selected(e.Meta)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return selected(e.Meta)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !selected(e.MetaNew) {
+				return false
+			}
+			if e.MetaOld == nil {
+				return true
+			}
+			if e.MetaNew.GetDeletionTimestamp() != nil {
+				return specChanged(e) ||
+					!reflect.DeepEqual(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) ||
+					!reflect.DeepEqual(e.MetaOld.GetDeletionTimestamp(), e.MetaNew.GetDeletionTimestamp())
+			}
+			if !isPaused(e.MetaOld) || !isPaused(e.MetaNew) {
+				return true
+			}
+			return !reflect.DeepEqual(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) ||
+				!reflect.DeepEqual(e.MetaOld.GetDeletionTimestamp(), e.MetaNew.GetDeletionTimestamp())
+		},
+	}
+}
+
+// specChanged - whether the update changes the spec of the CR. Without the
+// status subresource, writing the status also increments the generation, so
+// the specs are compared when the objects are available.
+func specChanged(e event.UpdateEvent) bool {
+	o, okOld := e.ObjectOld.(*unstructured.Unstructured)
+	n, okNew := e.ObjectNew.(*unstructured.Unstructured)
+	if !okOld || !okNew {
+		return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
+	}
+	return !reflect.DeepEqual(o.Object["spec"], n.Object["spec"])
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestPredicate(t *testing.T) {
+	selector, err := labels.Parse("tier=cache")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	meta := func(tier string, paused bool, annotations ...string) *metav1.ObjectMeta {
+		m := &metav1.ObjectMeta{Name: "example", Namespace: "default", Labels: map[string]string{}, Annotations: map[string]string{}}
+		if tier != "" {
+			m.Labels["tier"] = tier
+		}
+		if paused {
+			m.Annotations[PausedAnnotation] = "true"
+		}
+		for i := 0; i+1 < len(annotations); i += 2 {
+			m.Annotations[annotations[i]] = annotations[i+1]
+		}
+		return m
+	}
+	deleting := meta("cache", true)
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+	finalizing := meta("cache", false)
+	finalizing.SetDeletionTimestamp(&now)
+	finalizing.SetGeneration(2)
+	finalizingStatus := finalizing.DeepCopy()
+	finalizingStatus.SetResourceVersion("2")
+	finalizingAnnotated := meta("cache", false, ForceFinalizeAnnotation, "true")
+	finalizingAnnotated.SetDeletionTimestamp(&now)
+	finalizingAnnotated.SetGeneration(2)
+
+	testCases := []struct {
+		name     string
+		selector labels.Selector
+		create   *metav1.ObjectMeta
+		old, new *metav1.ObjectMeta
+		expected bool
+	}{
+		{
+			name:     "create of a selected resource",
+			selector: selector,
+			create:   meta("cache", false),
+			expected: true,
+		},
+		{
+			name:     "create of a resource not selected",
+			selector: selector,
+			create:   meta("web", false),
+			expected: false,
+		},
+		{
+			name:     "create of a resource without labels",
+			selector: labels.Everything(),
+			create:   meta("", false),
+			expected: true,
+		},
+		{
+			name:     "create of a paused resource",
+			selector: selector,
+			create:   meta("cache", true),
+			expected: true,
+		},
+		{
+			name:     "update of a selected resource",
+			selector: selector,
+			old:      meta("cache", false),
+			new:      meta("cache", false, "note", "updated"),
+			expected: true,
+		},
+		{
+			name:     "update of a resource no longer selected",
+			selector: selector,
+			old:      meta("cache", false),
+			new:      meta("web", false),
+			expected: false,
+		},
+		{
+			name:     "update pausing a resource",
+			selector: selector,
+			old:      meta("cache", false),
+			new:      meta("cache", true),
+			expected: true,
+		},
+		{
+			name:     "update of a paused resource",
+			selector: selector,
+			old:      meta("cache", true),
+			new:      meta("cache", true),
+			expected: false,
+		},
+		{
+			name:     "update unpausing a resource",
+			selector: selector,
+			old:      meta("cache", true),
+			new:      meta("cache", false),
+			expected: true,
+		},
+		{
+			name:     "update of the annotations of a paused resource",
+			selector: selector,
+			old:      meta("cache", true),
+			new:      meta("cache", true, ForceFinalizeAnnotation, "true"),
+			expected: true,
+		},
+		{
+			name:     "deletion of a paused resource",
+			selector: selector,
+			old:      meta("cache", true),
+			new:      deleting,
+			expected: true,
+		},
+		{
+			name:     "update of the status of a resource being deleted",
+			selector: selector,
+			old:      finalizing,
+			new:      finalizingStatus,
+			expected: false,
+		},
+		{
+			name:     "update of the annotations of a resource being deleted",
+			selector: selector,
+			old:      finalizing,
+			new:      finalizingAnnotated,
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPredicate(tc.selector)
+			if tc.create != nil {
+				if got := p.Create(event.CreateEvent{Meta: tc.create}); got != tc.expected {
+					t.Fatalf("unexpected create result: %v expected: %v", got, tc.expected)
+				}
+				if got := p.Delete(event.DeleteEvent{Meta: tc.create}); got != tc.expected {
+					t.Fatalf("unexpected delete result: %v expected: %v", got, tc.expected)
+				}
+				if got := p.Generic(event.GenericEvent{Meta: tc.create}); got != tc.expected {
+					t.Fatalf("unexpected generic result: %v expected: %v", got, tc.expected)
+				}
+				return
+			}
+			if got := p.Update(event.UpdateEvent{MetaOld: tc.old, MetaNew: tc.new}); got != tc.expected {
+				t.Fatalf("unexpected update result: %v expected: %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPredicateFinalizerStatusUpdate(t *testing.T) {
+	now := metav1.Now()
+	live := &metav1.ObjectMeta{Name: "example", Namespace: "default", Generation: 1, ResourceVersion: "1", Finalizers: []string{"finalizer.cache.example.com"}}
+	deleted := live.DeepCopy()
+	deleted.Generation = 2
+	deleted.ResourceVersion = "2"
+	deleted.DeletionTimestamp = &now
+	statusWritten := deleted.DeepCopy()
+	statusWritten.ResourceVersion = "3"
+	object := func(m *metav1.ObjectMeta, size int64) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"size": size}}}
+		u.SetName(m.Name)
+		u.SetNamespace(m.Namespace)
+		u.SetGeneration(m.Generation)
+		u.SetResourceVersion(m.ResourceVersion)
+		u.SetDeletionTimestamp(m.DeletionTimestamp)
+		return u
+	}
+	// Without the status subresource, writing the status increments the
+	// generation.
+	generationIncremented := statusWritten.DeepCopy()
+	generationIncremented.Generation = 3
+
+	testCases := []struct {
+		name             string
+		old, new         *metav1.ObjectMeta
+		oldSize, newSize int64
+		expected         int
+	}{
+		{
+			name:     "deletion of the resource",
+			old:      live,
+			new:      deleted,
+			expected: 1,
+		},
+		{
+			// The status written after a failed finalizer run leaves the retry
+			// to the RequeueAfter of the reconcile.
+			name:     "status written after a failed finalizer run",
+			old:      deleted,
+			new:      statusWritten,
+			expected: 0,
+		},
+		{
+			name:     "status written without the status subresource",
+			old:      deleted,
+			new:      generationIncremented,
+			oldSize:  3,
+			newSize:  3,
+			expected: 0,
+		},
+		{
+			name:     "update of the spec of a resource being deleted",
+			old:      deleted,
+			new:      generationIncremented,
+			oldSize:  3,
+			newSize:  4,
+			expected: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPredicate(labels.Everything())
+			h := &crthandler.EnqueueRequestForObject{}
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+			e := event.UpdateEvent{MetaOld: tc.old, MetaNew: tc.new}
+			if tc.oldSize != 0 {
+				e.ObjectOld, e.ObjectNew = object(tc.old, tc.oldSize), object(tc.new, tc.newSize)
+			}
+			if p.Update(e) {
+				h.Update(e, q)
+			}
+			if q.Len() != tc.expected {
+				t.Fatalf("unexpected number of queued requests: %d expected: %d", q.Len(), tc.expected)
+			}
+		})
+	}
+}
@@ -18,19 +18,26 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
 
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -40,6 +47,12 @@ const (
 	// To use create a CR with an annotation "ansible.operator-sdk/reconcile-period: 30s" or some other valid
 	// Duration. This will override the operators/or controllers reconcile period for that particular CR.
 	ReconcilePeriodAnnotation = "ansible.operator-sdk/reconcile-period"
+
+	// ForceFinalizeAnnotation - annotation used by a user to remove the
+	// finalizer of a CR that is being deleted without running it, for
+	// instance when the finalizer keeps failing. To use set the annotation
+	// "ansible.operator-sdk/force-finalize: true" on the CR.
+	ForceFinalizeAnnotation = "ansible.operator-sdk/force-finalize"
 )
 
 // AnsibleOperatorReconciler - object to reconcile runner requests
@@ -49,6 +62,30 @@ type AnsibleOperatorReconciler struct {
 	Client          client.Client
 	EventHandlers   []events.EventHandler
 	ReconcilePeriod time.Duration
+	// Dispatcher - delivers the events of the runs to EventHandlers.
+	Dispatcher *events.Dispatcher
+	// StatusSubresource - the CRD of the GVK has the status subresource
+	// enabled, so status writes must go through it.
+	StatusSubresource bool
+	// SkipUnchanged - skip runs when the resource did not change since the
+	// last successful run. The runs of the resources enqueued because one of
+	// their dependent resources changed are not skipped, so that the drift
+	// of the dependent resources is corrected, but the periodic runs are, so
+	// the drift of the dependent resources that are not watched is not.
+	SkipUnchanged bool
+	// ChangedOwners - the resources enqueued because one of their dependent
+	// resources changed.
+	ChangedOwners *handler.ChangedOwners
+	// Selector - selects the resources reconciled, the others are left to
+	// the operators selecting them.
+	Selector labels.Selector
+	// Tokens - the tokens of the runs, checked by the proxy.
+	Tokens *kubeconfig.Tokens
+	// ProxyURL - the URL of the proxy the runs connect to.
+	ProxyURL string
+	// APIClient - reads the dependent resources owned through annotations
+	// from the API, since they may not be in the cache of the manager.
+	APIClient client.Client
 }
 
 // Reconcile - handle the event.
@@ -57,11 +94,23 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	u.SetGroupVersionKind(r.GVK)
 	err := r.Client.Get(context.TODO(), request.NamespacedName, u)
 	if apierrors.IsNotFound(err) {
+		r.ChangedOwners.Take(request.NamespacedName)
+		// The artifacts of the runs are kept as long as the resource exists.
+		inputDir := runner.InputDirPath(r.GVK, request.Namespace, request.Name)
+		if err := os.RemoveAll(inputDir); err != nil {
+			logrus.Warnf("Unable to remove %v: %v", inputDir, err)
+		}
 		return reconcile.Result{}, nil
 	}
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	// Owners of dependent resources are enqueued without going through the
+	// predicate of the controller.
+	if r.Selector != nil && !r.Selector.Matches(labels.Set(u.GetLabels())) {
+		logrus.Debugf("%s/%s is not selected by %v, skipping", u.GetNamespace(), u.GetName(), r.Selector)
+		return reconcile.Result{}, nil
+	}
 	reconcileResult := reconcile.Result{RequeueAfter: r.ReconcilePeriod}
 	if ds, ok := u.GetAnnotations()[ReconcilePeriodAnnotation]; ok {
 		duration, err := time.ParseDuration(ds)
@@ -72,6 +121,14 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	}
 
 	deleted := u.GetDeletionTimestamp() != nil
+	paused := isPaused(u)
+	if paused && !(deleted && u.GetAnnotations()[ForceFinalizeAnnotation] == "true") {
+		logrus.Infof("%s is set on %s/%s, skipping reconcilation", PausedAnnotation, u.GetNamespace(), u.GetName())
+		return reconcile.Result{}, r.setPaused(u, true)
+	}
+	if err := r.setPaused(u, false); err != nil {
+		return reconcileResult, err
+	}
 	finalizer, finalizerExists := r.Runner.GetFinalizer()
 	pendingFinalizers := u.GetFinalizers()
 	// If the resource is being deleted we don't want to add the finalizer again
@@ -82,10 +139,19 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		err := r.Client.Update(context.TODO(), u)
 		return reconcileResult, err
 	}
+	// The dependent resources owned through annotations are deleted once
+	// the finalizer of the watch is done.
+	if deleted && !contains(pendingFinalizers, finalizer) && contains(pendingFinalizers, DependentsFinalizer) {
+		return reconcileResult, r.deleteAnnotatedDependents(u)
+	}
 	if !contains(pendingFinalizers, finalizer) && deleted {
 		logrus.Info("Resource is terminated, skipping reconcilation")
 		return reconcileResult, nil
 	}
+	if deleted && u.GetAnnotations()[ForceFinalizeAnnotation] == "true" {
+		logrus.Warnf("%s is set on %s/%s, removing finalizer %s without running it", ForceFinalizeAnnotation, u.GetNamespace(), u.GetName(), finalizer)
+		return reconcile.Result{}, r.removeFinalizer(u, finalizer)
+	}
 
 	spec := u.Object["spec"]
 	_, ok := spec.(map[string]interface{})
@@ -99,12 +165,17 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		reconcileResult.Requeue = true
 		return reconcileResult, nil
 	}
-	status := u.Object["status"]
-	_, ok = status.(map[string]interface{})
-	if !ok {
-		logrus.Debugf("status was not found")
-		u.Object["status"] = map[string]interface{}{}
-		err = r.Client.Update(context.TODO(), u)
+	// If status is missing or an empty map we can assume CR was just created
+	if sm, ok := u.Object["status"].(map[string]interface{}); !ok || len(sm) == 0 {
+		logrus.Debugf("Setting %v condition", RunningConditionType)
+		s := ResourceStatus{}
+		SetCondition(&s, *NewCondition(RunningConditionType, corev1.ConditionTrue, nil, RunningReason, RunningMessage))
+		sm, err := s.ToMap()
+		if err != nil {
+			return reconcileResult, err
+		}
+		u.Object["status"] = sm
+		err = r.updateStatus(u)
 		if err != nil {
 			return reconcileResult, err
 		}
@@ -112,18 +183,18 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		return reconcileResult, nil
 	}
 
-	// If status is an empty map we can assume CR was just created
-	if len(u.Object["status"].(map[string]interface{})) == 0 {
-		logrus.Debugf("Setting phase status to %v", StatusPhaseCreating)
-		u.Object["status"] = ResourceStatus{
-			Phase: StatusPhaseCreating,
-		}
-		err = r.Client.Update(context.TODO(), u)
+	var parametersHash string
+	if r.SkipUnchanged && !deleted {
+		parametersHash, err = r.Runner.GetParametersHash(context.TODO(), u, r.Client)
 		if err != nil {
 			return reconcileResult, err
 		}
-		reconcileResult.Requeue = true
-		return reconcileResult, nil
+		s := NewStatusFromMap(u.Object["status"].(map[string]interface{}))
+		dependentChanged := r.ChangedOwners.Take(request.NamespacedName)
+		if !dependentChanged && IsUnchanged(s, u.GetGeneration(), parametersHash, r.StatusSubresource) {
+			logrus.Debugf("%s/%s has not changed since the last successful run, skipping", u.GetNamespace(), u.GetName())
+			return reconcileResult, nil
+		}
 	}
 
 	ownerRef := metav1.OwnerReference{
@@ -133,77 +204,179 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 		UID:        u.GetUID(),
 	}
 
-	kc, err := kubeconfig.Create(ownerRef, "http://localhost:8888", u.GetNamespace())
+	// The token authenticates the requests of the run to the proxy until
+	// the run finishes.
+	token, err := r.Tokens.Add(kubeconfig.Owner{OwnerReference: ownerRef, Namespace: u.GetNamespace()})
+	if err != nil {
+		return reconcileResult, err
+	}
+	defer r.Tokens.Remove(token)
+	kc, err := kubeconfig.Create(token, r.ProxyURL, u.GetNamespace())
 	if err != nil {
 		return reconcileResult, err
 	}
 	defer os.Remove(kc.Name())
-	eventChan, err := r.Runner.Run(u, kc.Name())
+	ctx := context.TODO()
+	timeout, timeoutExists := r.Runner.GetTimeout()
+	if deleted {
+		if t, ok := r.Runner.GetFinalizerTimeout(); ok {
+			timeout, timeoutExists = t, true
+		}
+	}
+	if timeoutExists {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	gvkLabel := metrics.GVKLabel(r.GVK)
+	runType := metrics.RunTypeReconcile
+	if deleted {
+		runType = metrics.RunTypeFinalizer
+	}
+	start := time.Now()
+	eventChan, err := r.Runner.Run(ctx, u, kc.Name(), r.Client)
 	if err != nil {
 		return reconcileResult, err
 	}
+	metrics.RunsInFlight.WithLabelValues(gvkLabel).Inc()
+
+	// The handlers get a copy of the resource as it was when the run
+	// started, since u is updated below while they may still be handling.
+	snapshot := u.DeepCopy()
 
 	// iterate events from ansible, looking for the final one
-	statusEvent := eventapi.StatusJobEvent{}
+	var statsEvent *eventapi.JobEvent
+	var failedTask *FailedTask
+	customStatus := map[string]interface{}{}
 	for event := range eventChan {
-		for _, eHandler := range r.EventHandlers {
-			go eHandler.Handle(u, event)
+		r.Dispatcher.Dispatch(snapshot, event)
+		if f := NewFailedTaskFromJobEvent(event); f != nil {
+			failedTask = f
+			metrics.TaskFailures.WithLabelValues(gvkLabel, f.Action).Inc()
+		}
+		for k, v := range NewCustomStatusFromJobEvent(event) {
+			customStatus[k] = v
 		}
-		if event.Event == "playbook_on_stats" {
-			// convert to StatusJobEvent; would love a better way to do this
-			data, err := json.Marshal(event)
-			if err != nil {
-				return reconcile.Result{}, err
-			}
-			err = json.Unmarshal(data, &statusEvent)
-			if err != nil {
-				return reconcile.Result{}, err
-			}
+		if event.Event == events.EventPlaybookOnStats {
+			e := event
+			statsEvent = &e
 		}
 	}
-	if statusEvent.Event == "" {
-		err := errors.New("did not receive playbook_on_stats event")
-		logrus.Error(err.Error())
+	metrics.RunsInFlight.WithLabelValues(gvkLabel).Dec()
+	metrics.RunDuration.WithLabelValues(gvkLabel, runType).Observe(time.Since(start).Seconds())
+
+	if err := r.recordAnnotatedDependents(u, r.Tokens.Dependents(token)); err != nil {
 		return reconcileResult, err
 	}
 
-	// We only want to update the CustomResource once, so we'll track changes and do it at the end
-	var needsUpdate bool
+	statusEvent := eventapi.StatusJobEvent{}
+	if statsEvent != nil {
+		// convert to StatusJobEvent; would love a better way to do this
+		data, err := json.Marshal(statsEvent)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		err = json.Unmarshal(data, &statusEvent)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 	runSuccessful := true
+	if statusEvent.Event == "" {
+		if ctx.Err() != context.DeadlineExceeded {
+			metrics.Runs.WithLabelValues(gvkLabel, runType, metrics.OutcomeFailed).Inc()
+			err := errors.New("did not receive playbook_on_stats event")
+			logrus.Error(err.Error())
+			return reconcileResult, err
+		}
+		// The run was killed before it could report its stats, record it
+		// as failed.
+		metrics.Runs.WithLabelValues(gvkLabel, runType, metrics.OutcomeTimeout).Inc()
+		logrus.Errorf("ansible run for %s/%s timed out after %v", u.GetNamespace(), u.GetName(), timeout)
+		failedTask = &FailedTask{
+			Reason:  TimeoutReason,
+			Message: fmt.Sprintf("ansible run did not complete within %v", timeout),
+		}
+		statusEvent.Created = eventapi.EventTime{Time: time.Now()}
+		runSuccessful = false
+	}
 	for _, count := range statusEvent.EventData.Failures {
 		if count > 0 {
 			runSuccessful = false
 			break
 		}
 	}
-	// The finalizer has run successfully, time to remove it
-	if deleted && finalizerExists && runSuccessful {
-		finalizers := []string{}
-		for _, pendingFinalizer := range pendingFinalizers {
-			if pendingFinalizer != finalizer {
-				finalizers = append(finalizers, pendingFinalizer)
-			}
+	if statsEvent != nil {
+		outcome := metrics.OutcomeSuccessful
+		if !runSuccessful {
+			outcome = metrics.OutcomeFailed
 		}
-		u.SetFinalizers(finalizers)
-		needsUpdate = true
+		metrics.Runs.WithLabelValues(gvkLabel, runType, outcome).Inc()
 	}
 
+	// We only want to update the status once, so we'll track changes and do it at the end
+	var statusChanged bool
 	statusMap, ok := u.Object["status"].(map[string]interface{})
 	if !ok {
-		u.Object["status"] = ResourceStatus{
-			Status: NewStatusFromStatusJobEvent(statusEvent),
-		}
 		logrus.Infof("adding status for the first time")
-		needsUpdate = true
-	} else {
-		// Need to conver the map[string]interface into a resource status.
-		if update, status := UpdateResourceStatus(statusMap, statusEvent); update {
-			u.Object["status"] = status
-			needsUpdate = true
+		statusMap = map[string]interface{}{}
+	}
+	finalizerAttempts := 0
+	if deleted && !runSuccessful {
+		if failedTask != nil && failedTask.Reason == "" {
+			failedTask.Reason = FinalizerFailedReason
 		}
+		finalizerAttempts = RecordFinalizerAttempt(statusMap)
+		statusChanged = true
 	}
-	if needsUpdate {
-		err = r.Client.Update(context.TODO(), u)
+	// Need to convert the map[string]interface into a resource status.
+	if update, status := UpdateResourceStatus(statusMap, statusEvent, failedTask); update {
+		sm, err := status.ToMap()
+		if err != nil {
+			return reconcileResult, err
+		}
+		for k, v := range sm {
+			statusMap[k] = v
+		}
+		statusChanged = true
+	}
+	// Keep the fields published by the playbook or role next to the ones
+	// managed by the operator.
+	if MergeCustomStatus(statusMap, customStatus) {
+		statusChanged = true
+	}
+	// Keep the spec the run succeeded with, the next run gets it as the
+	// previous spec.
+	if !deleted && runSuccessful {
+		if spec, ok := u.Object["spec"].(map[string]interface{}); ok && SetLastAppliedSpec(statusMap, spec) {
+			statusChanged = true
+		}
+	}
+	if r.SkipUnchanged && !deleted && runSuccessful {
+		if SetObservedState(statusMap, u.GetGeneration(), parametersHash) {
+			statusChanged = true
+		}
+	}
+	u.Object["status"] = statusMap
+	if statusChanged {
+		err = r.updateStatus(u)
+		if err != nil {
+			return reconcileResult, err
+		}
+	}
+
+	// The finalizer has run successfully, time to remove it
+	if deleted && finalizerExists && runSuccessful {
+		err = r.removeFinalizer(u, finalizer)
+	}
+	if deleted && finalizerExists && !runSuccessful {
+		retry := r.Runner.GetFinalizerRetry()
+		if retry.MaxAttempts > 0 && finalizerAttempts >= retry.MaxAttempts {
+			logrus.Errorf("finalizer %s of %s/%s failed %d times, removing it", finalizer, u.GetNamespace(), u.GetName(), finalizerAttempts)
+			return reconcile.Result{}, r.removeFinalizer(u, finalizer)
+		}
+		logrus.Infof("finalizer %s of %s/%s failed %d times, retrying in %v", finalizer, u.GetNamespace(), u.GetName(), finalizerAttempts, retry.Delay(finalizerAttempts))
+		reconcileResult.RequeueAfter = retry.Delay(finalizerAttempts)
 	}
 	if !runSuccessful {
 		reconcileResult.Requeue = true
@@ -212,6 +385,64 @@ func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconc
 	return reconcileResult, err
 }
 
+// removeFinalizer removes finalizer from u, letting the deletion of u proceed.
+func (r *AnsibleOperatorReconciler) removeFinalizer(u *unstructured.Unstructured, finalizer string) error {
+	finalizers := []string{}
+	for _, pendingFinalizer := range u.GetFinalizers() {
+		if pendingFinalizer != finalizer {
+			finalizers = append(finalizers, pendingFinalizer)
+		}
+	}
+	u.SetFinalizers(finalizers)
+	return r.Client.Update(context.TODO(), u)
+}
+
+// setPaused sets or removes the Paused condition in the status of u, and
+// writes the status when it changed.
+func (r *AnsibleOperatorReconciler) setPaused(u *unstructured.Unstructured, paused bool) error {
+	sm, ok := u.Object["status"].(map[string]interface{})
+	if !ok {
+		if !paused {
+			return nil
+		}
+		sm = map[string]interface{}{}
+	}
+	changed, err := SetPaused(sm, paused)
+	if err != nil || !changed {
+		return err
+	}
+	u.Object["status"] = sm
+	return r.updateStatus(u)
+}
+
+// updateStatus writes the status of u, through the status subresource when
+// the CRD enables it. On a conflict the latest version of the resource is
+// fetched and the write is retried with the same status.
+func (r *AnsibleOperatorReconciler) updateStatus(u *unstructured.Unstructured) error {
+	status := u.Object["status"]
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var err error
+		if r.StatusSubresource {
+			err = r.Client.Status().Update(context.TODO(), u)
+		} else {
+			err = r.Client.Update(context.TODO(), u)
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		logrus.Debugf("conflict updating status of %s/%s, retrying", u.GetNamespace(), u.GetName())
+		latest := &unstructured.Unstructured{}
+		latest.SetGroupVersionKind(r.GVK)
+		getErr := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}, latest)
+		if getErr != nil {
+			return getErr
+		}
+		latest.Object["status"] = status
+		u.Object = latest.Object
+		return err
+	})
+}
+
 func contains(l []string, s string) bool {
 	for _, elem := range l {
 		if elem == s {
@@ -15,17 +15,62 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 const (
-	host                = "localhost"
-	StatusPhaseCreating = "Creating"
-	StatusPhaseRunning  = "Running"
-	StatusPhaseFailed   = "Failed"
+	host = "localhost"
+
+	// maxHistory - the number of previous ansible results kept in the status.
+	maxHistory = 10
 )
 
-type Status struct {
+// ConditionType - type of a condition found in the status of a CR.
+type ConditionType string
+
+const (
+	// RunningConditionType - the operator is reconciling the resource.
+	RunningConditionType ConditionType = "Running"
+	// SuccessfulConditionType - the last ansible run succeeded.
+	SuccessfulConditionType ConditionType = "Successful"
+	// FailureConditionType - the last ansible run failed.
+	FailureConditionType ConditionType = "Failure"
+	// PausedConditionType - the reconciliation of the resource is paused by
+	// PausedAnnotation.
+	PausedConditionType ConditionType = "Paused"
+
+	RunningReason       = "Running"
+	SuccessfulReason    = "Successful"
+	FailedReason        = "Failed"
+	UnknownFailedReason = "Unknown"
+	TimeoutReason       = "Timeout"
+	// FinalizerFailedReason - a finalizer run failed, it is retried with a
+	// backoff.
+	FinalizerFailedReason = "FinalizerFailed"
+	PausedReason          = "Paused"
+
+	RunningMessage    = "Running reconciliation"
+	SuccessfulMessage = "Awaiting next reconciliation"
+	PausedMessage     = "Reconciliation is paused by the " + PausedAnnotation + " annotation"
+
+	// StatusFactName - the fact a playbook or role sets with set_fact to
+	// publish custom fields in the status of the CR.
+	StatusFactName = "operator_sdk_status"
+)
+
+// AnsibleResult - the summary of an ansible run.
+type AnsibleResult struct {
 	Ok               int                `json:"ok"`
 	Changed          int                `json:"changed"`
 	Skipped          int                `json:"skipped"`
@@ -33,7 +78,9 @@ type Status struct {
 	TimeOfCompletion eventapi.EventTime `json:"completion"`
 }
 
-func NewStatusFromStatusJobEvent(je eventapi.StatusJobEvent) Status {
+// NewAnsibleResultFromStatusJobEvent - creates an AnsibleResult from the
+// playbook_on_stats event of a run.
+func NewAnsibleResultFromStatusJobEvent(je eventapi.StatusJobEvent) *AnsibleResult {
 	// ok events.
 	o := 0
 	changed := 0
@@ -51,7 +98,7 @@ func NewStatusFromStatusJobEvent(je eventapi.StatusJobEvent) Status {
 	if v, ok := je.EventData.Failures[host]; ok {
 		failures = v
 	}
-	return Status{
+	return &AnsibleResult{
 		Ok:               o,
 		Changed:          changed,
 		Skipped:          skipped,
@@ -60,77 +107,361 @@ func NewStatusFromStatusJobEvent(je eventapi.StatusJobEvent) Status {
 	}
 }
 
-func IsStatusEqual(s1, s2 Status) bool {
-	return (s1.Ok == s2.Ok && s1.Changed == s2.Changed && s1.Skipped == s2.Skipped && s1.Failures == s2.Failures)
+// IsAnsibleResultEqual - compares the counters of two results, ignoring the
+// time of completion.
+func IsAnsibleResultEqual(r1, r2 *AnsibleResult) bool {
+	if r1 == nil || r2 == nil {
+		return r1 == r2
+	}
+	return r1.Ok == r2.Ok && r1.Changed == r2.Changed && r1.Skipped == r2.Skipped && r1.Failures == r2.Failures
 }
 
-func NewStatusFromMap(sm map[string]interface{}) Status {
-	//Create Old top level status
-	// ok events.
-	o := 0
-	changed := 0
-	skipped := 0
-	failures := 0
-	e := eventapi.EventTime{}
-	if v, ok := sm["changed"]; ok {
-		changed = int(v.(int64))
+// FailedTask - the task that caused an ansible run to fail.
+type FailedTask struct {
+	Name string
+	// Action - the module run by the task, such as k8s or command.
+	Action  string
+	Message string
+	// Reason - the reason recorded in the conditions, FailedReason when
+	// empty.
+	Reason string
+}
+
+// NewFailedTaskFromJobEvent - returns the failed task described by a
+// runner_on_failed event, or nil if the event is not a failure or the failure
+// was ignored by the task.
+func NewFailedTaskFromJobEvent(e eventapi.JobEvent) *FailedTask {
+	if e.Event != events.EventRunnerOnFailed {
+		return nil
 	}
-	if v, ok := sm["ok"]; ok {
-		o = int(v.(int64))
+	if ignore, ok := e.EventData["ignore_errors"].(bool); ok && ignore {
+		return nil
 	}
-	if v, ok := sm["skipped"]; ok {
-		skipped = int(v.(int64))
+	f := &FailedTask{}
+	if t, ok := e.EventData["task"].(string); ok {
+		f.Name = t
 	}
-	if v, ok := sm["failures"]; ok {
-		failures = int(v.(int64))
+	if a, ok := e.EventData["task_action"].(string); ok {
+		f.Action = a
 	}
-	if v, ok := sm["completion"]; ok {
-		s := v.(string)
-		e.UnmarshalJSON([]byte(s))
+	if res, ok := e.EventData["res"].(map[string]interface{}); ok {
+		if msg, ok := res["msg"]; ok {
+			f.Message = fmt.Sprintf("%v", msg)
+		}
 	}
-	return Status{
-		Ok:               o,
-		Changed:          changed,
-		Skipped:          skipped,
-		Failures:         failures,
-		TimeOfCompletion: e,
+	return f
+}
+
+// NewCustomStatusFromJobEvent - returns the custom status fields set by a
+// set_fact task on StatusFactName, or nil if the event does not set them.
+func NewCustomStatusFromJobEvent(e eventapi.JobEvent) map[string]interface{} {
+	if e.Event != events.EventRunnerOnOk || e.EventData["task_action"] != events.TaskActionSetFact {
+		return nil
 	}
+	res, ok := e.EventData["res"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	facts, ok := res["ansible_facts"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	custom, ok := facts[StatusFactName].(map[string]interface{})
+	if !ok {
+		if _, found := facts[StatusFactName]; found {
+			logrus.Warnf("%s must be a dictionary, ignoring it", StatusFactName)
+		}
+		return nil
+	}
+	return custom
+}
+
+// Condition - the state of the resource at a certain point in time.
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	FailedTask         string                 `json:"failedTask,omitempty"`
+	AnsibleResult      *AnsibleResult         `json:"ansibleResult,omitempty"`
 }
 
+// NewCondition - creates a condition with the transition time set to now.
+func NewCondition(t ConditionType, s corev1.ConditionStatus, r *AnsibleResult, reason, message string) *Condition {
+	return &Condition{
+		Type:               t,
+		Status:             s,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+		AnsibleResult:      r,
+	}
+}
+
+// ResourceStatus - the status the ansible operator manages for a CR.
 type ResourceStatus struct {
-	Status         `json:",inline"`
-	Phase          string   `json:"phase"`
-	FailureMessage string   `json:"reason,omitempty"`
-	History        []Status `json:"history,omitempty"`
+	Conditions         []Condition      `json:"conditions"`
+	History            []*AnsibleResult `json:"history,omitempty"`
+	ObservedGeneration int64            `json:"observedGeneration,omitempty"`
+	ExtraVarsHash      string           `json:"extraVarsHash,omitempty"`
+	// LastAppliedSpec - the spec of the last successful run, passed to the
+	// next run as the previous spec.
+	LastAppliedSpec map[string]interface{} `json:"lastAppliedSpec,omitempty"`
+	// FinalizerAttempts - the number of failed finalizer runs.
+	FinalizerAttempts int `json:"finalizerAttempts,omitempty"`
 }
 
-func UpdateResourceStatus(sm map[string]interface{}, je eventapi.StatusJobEvent) (bool, ResourceStatus) {
-	newStatus := NewStatusFromStatusJobEvent(je)
-	oldStatus := NewStatusFromMap(sm)
-	phase := StatusPhaseRunning
-	// Don't update the status if new status and old status are equal.
-	if IsStatusEqual(newStatus, oldStatus) {
-		return false, ResourceStatus{}
+// isManagedStatusField - returns true if the field of the status is managed
+// by the operator and must not be set by a playbook or role.
+func isManagedStatusField(field string) bool {
+	switch field {
+	case "conditions", "history", "observedGeneration", "extraVarsHash", runner.LastAppliedSpecField, "finalizerAttempts":
+		return true
 	}
+	return false
+}
+
+// SetObservedState - records the generation of the resource and the hash of
+// the extravars used by a successful run in the status map. Returns true if
+// either of them changed.
+func SetObservedState(sm map[string]interface{}, generation int64, hash string) bool {
+	s := NewStatusFromMap(sm)
+	if s.ObservedGeneration == generation && s.ExtraVarsHash == hash {
+		return false
+	}
+	sm["observedGeneration"] = generation
+	sm["extraVarsHash"] = hash
+	return true
+}
+
+// SetLastAppliedSpec - records spec as the spec of the last successful run
+// and returns true if it changed.
+func SetLastAppliedSpec(sm map[string]interface{}, spec map[string]interface{}) bool {
+	oldSpec, err := json.Marshal(sm[runner.LastAppliedSpecField])
+	if err != nil {
+		logrus.Warnf("unable to marshal the last applied spec: %v", err)
+	}
+	newSpec, err := json.Marshal(spec)
+	if err != nil {
+		logrus.Warnf("unable to marshal the spec, not recording it: %v", err)
+		return false
+	}
+	if bytes.Equal(oldSpec, newSpec) {
+		return false
+	}
+	sm[runner.LastAppliedSpecField] = runtime.DeepCopyJSONValue(spec)
+	return true
+}
 
-	history := []Status{}
-	h, ok := sm["history"]
-	if ok {
-		hi := h.([]interface{})
-		for _, m := range hi {
-			ma := m.(map[string]interface{})
-			history = append(history, NewStatusFromMap(ma))
+// RecordFinalizerAttempt - counts a failed finalizer run in the status map
+// and returns the number of failed finalizer runs.
+func RecordFinalizerAttempt(sm map[string]interface{}) int {
+	attempts := NewStatusFromMap(sm).FinalizerAttempts + 1
+	sm["finalizerAttempts"] = int64(attempts)
+	return attempts
+}
+
+// SetPaused - sets the Paused condition in the status map when paused is
+// true and removes it otherwise. Returns true if the conditions changed.
+func SetPaused(sm map[string]interface{}, paused bool) (bool, error) {
+	s := NewStatusFromMap(sm)
+	if (GetCondition(s, PausedConditionType) != nil) == paused {
+		return false, nil
+	}
+	if paused {
+		SetCondition(&s, *NewCondition(PausedConditionType, corev1.ConditionTrue, nil, PausedReason, PausedMessage))
+	} else {
+		RemoveCondition(&s, PausedConditionType)
+	}
+	m, err := s.ToMap()
+	if err != nil {
+		return false, err
+	}
+	sm["conditions"] = m["conditions"]
+	return true, nil
+}
+
+// IsUnchanged - returns true if the last run was successful and used the same
+// extravars. The generation is only compared when checkGeneration is set,
+// since it is bumped by status updates when the status subresource is not
+// enabled.
+func IsUnchanged(s ResourceStatus, generation int64, hash string, checkGeneration bool) bool {
+	c := GetCondition(s, SuccessfulConditionType)
+	if c == nil || c.Status != corev1.ConditionTrue {
+		return false
+	}
+	if checkGeneration && s.ObservedGeneration != generation {
+		return false
+	}
+	return s.ExtraVarsHash != "" && s.ExtraVarsHash == hash
+}
+
+// ToMap - converts the status into a map that can be set on an unstructured
+// object.
+func (s ResourceStatus) ToMap() (map[string]interface{}, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	sm := map[string]interface{}{}
+	err = json.Unmarshal(b, &sm)
+	return sm, err
+}
+
+// MergeCustomStatus - sets the custom fields published by a playbook or role
+// on the status map, and returns true if any of them changed. Fields managed
+// by the operator are never overwritten.
+func MergeCustomStatus(sm map[string]interface{}, custom map[string]interface{}) bool {
+	changed := false
+	for k, v := range custom {
+		if isManagedStatusField(k) {
+			logrus.Warnf("status field %s is managed by the operator, ignoring it", k)
+			continue
+		}
+		// Values read from the API server and from ansible may not share the
+		// same numeric types, so compare the serialized forms.
+		oldValue, err := json.Marshal(sm[k])
+		if err != nil {
+			logrus.Warnf("unable to marshal status field %s: %v", k, err)
+		}
+		newValue, err := json.Marshal(v)
+		if err != nil {
+			logrus.Warnf("unable to marshal status field %s, ignoring it: %v", k, err)
+			continue
+		}
+		if _, ok := sm[k]; !ok || !bytes.Equal(oldValue, newValue) {
+			sm[k] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// NewStatusFromMap - converts the status found on an unstructured object into
+// a ResourceStatus. Fields that can not be converted are dropped.
+func NewStatusFromMap(sm map[string]interface{}) ResourceStatus {
+	s := ResourceStatus{}
+	b, err := json.Marshal(sm)
+	if err != nil {
+		logrus.Warnf("unable to marshal status: %v", err)
+		return s
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		logrus.Warnf("unable to unmarshal status, starting with an empty one: %v", err)
+		return ResourceStatus{}
+	}
+	return s
+}
+
+// GetCondition - returns the condition of the given type, or nil if it is not
+// set.
+func GetCondition(s ResourceStatus, t ConditionType) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition - adds or replaces the condition of the same type. The
+// transition time is kept when the status of the condition did not change.
+func SetCondition(s *ResourceStatus, c Condition) {
+	current := GetCondition(*s, c.Type)
+	if current == nil {
+		s.Conditions = append(s.Conditions, c)
+		return
+	}
+	if current.Status == c.Status {
+		c.LastTransitionTime = current.LastTransitionTime
+	}
+	*current = c
+}
+
+// RemoveCondition - removes the condition of the given type.
+func RemoveCondition(s *ResourceStatus, t ConditionType) {
+	conditions := []Condition{}
+	for _, c := range s.Conditions {
+		if c.Type != t {
+			conditions = append(conditions, c)
+		}
+	}
+	s.Conditions = conditions
+}
+
+// isConditionEqual - compares two conditions ignoring the timestamps.
+func isConditionEqual(c1, c2 *Condition) bool {
+	if c1 == nil || c2 == nil {
+		return c1 == c2
+	}
+	return c1.Type == c2.Type && c1.Status == c2.Status && c1.Reason == c2.Reason &&
+		c1.Message == c2.Message && c1.FailedTask == c2.FailedTask &&
+		IsAnsibleResultEqual(c1.AnsibleResult, c2.AnsibleResult)
+}
+
+// lastAnsibleResult - the result recorded by the previous run, if any.
+func lastAnsibleResult(s ResourceStatus) *AnsibleResult {
+	for _, t := range []ConditionType{SuccessfulConditionType, FailureConditionType} {
+		if c := GetCondition(s, t); c != nil && c.Status == corev1.ConditionTrue {
+			return c.AnsibleResult
+		}
+	}
+	return nil
+}
+
+// UpdateResourceStatus - applies the outcome of an ansible run to the status
+// found on the resource. failure is nil when no task failed. When the run
+// reported its stats, they decide whether it failed, so that a failed task
+// rescued by the playbook does not fail it. Without the stats, e.g. when the run
+// timed out, it failed when failure is set. The returned bool is false when
+// nothing other than timestamps changed.
+func UpdateResourceStatus(sm map[string]interface{}, je eventapi.StatusJobEvent, failure *FailedTask) (bool, ResourceStatus) {
+	oldStatus := NewStatusFromMap(sm)
+	newStatus := NewStatusFromMap(sm)
+	result := NewAnsibleResultFromStatusJobEvent(je)
+
+	// The stats of the run are in je when it reported them.
+	successful := result.Failures == 0 && (failure == nil || je.Event != "")
+	if successful {
+		SetCondition(&newStatus, *NewCondition(RunningConditionType, corev1.ConditionTrue, nil, SuccessfulReason, SuccessfulMessage))
+		SetCondition(&newStatus, *NewCondition(SuccessfulConditionType, corev1.ConditionTrue, result, SuccessfulReason, SuccessfulMessage))
+		RemoveCondition(&newStatus, FailureConditionType)
+	} else {
+		reason := FailedReason
+		failureCondition := NewCondition(FailureConditionType, corev1.ConditionTrue, result, reason, "")
+		if failure != nil {
+			failureCondition.FailedTask = failure.Name
+			failureCondition.Message = failure.Message
+			if failure.Reason != "" {
+				failureCondition.Reason = failure.Reason
+			}
+		} else {
+			failureCondition.Reason = UnknownFailedReason
+			failureCondition.Message = "ansible run reported failures, but no failed task was found"
 		}
+		SetCondition(&newStatus, *NewCondition(RunningConditionType, corev1.ConditionFalse, nil, failureCondition.Reason, failureCondition.Message))
+		SetCondition(&newStatus, *NewCondition(SuccessfulConditionType, corev1.ConditionFalse, nil, failureCondition.Reason, failureCondition.Message))
+		SetCondition(&newStatus, *failureCondition)
 	}
 
-	if newStatus.Failures > 0 {
-		phase = StatusPhaseFailed
+	changed := len(oldStatus.Conditions) != len(newStatus.Conditions)
+	for _, c := range newStatus.Conditions {
+		if !isConditionEqual(GetCondition(oldStatus, c.Type), &c) {
+			changed = true
+			break
+		}
+	}
+	// Don't update the status if new status and old status are equal.
+	if !changed {
+		return false, ResourceStatus{}
 	}
 
-	history = append(history, oldStatus)
-	return true, ResourceStatus{
-		Status:  newStatus,
-		Phase:   phase,
-		History: history,
+	if old := lastAnsibleResult(oldStatus); old != nil {
+		newStatus.History = append(newStatus.History, old)
+	}
+	if len(newStatus.History) > maxHistory {
+		newStatus.History = newStatus.History[len(newStatus.History)-maxHistory:]
 	}
+	return true, newStatus
 }
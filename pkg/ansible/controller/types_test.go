@@ -0,0 +1,455 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// statsEvent - returns the playbook_on_stats event of a run with the given
+// counters.
+func statsEvent(ok, changed, failures int) eventapi.StatusJobEvent {
+	return eventapi.StatusJobEvent{
+		Event: "playbook_on_stats",
+		EventData: eventapi.StatsEventData{
+			Ok:       map[string]int{host: ok},
+			Changed:  map[string]int{host: changed},
+			Failures: map[string]int{host: failures},
+			Skipped:  map[string]int{host: 0},
+		},
+		Created: eventapi.EventTime{Time: time.Date(2018, 10, 12, 13, 41, 45, 0, time.UTC)},
+	}
+}
+
+// statusMap - returns s as found on an unstructured object.
+func statusMap(t *testing.T, s ResourceStatus) map[string]interface{} {
+	sm, err := s.ToMap()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return sm
+}
+
+func TestUpdateResourceStatus(t *testing.T) {
+	successful := ResourceStatus{}
+	SetCondition(&successful, *NewCondition(RunningConditionType, corev1.ConditionTrue, nil, SuccessfulReason, SuccessfulMessage))
+	SetCondition(&successful, *NewCondition(SuccessfulConditionType, corev1.ConditionTrue, &AnsibleResult{Ok: 2}, SuccessfulReason, SuccessfulMessage))
+	fullHistory := successful
+	for i := 0; i < maxHistory; i++ {
+		fullHistory.History = append(fullHistory.History, &AnsibleResult{Ok: 100 + i})
+	}
+
+	testCases := []struct {
+		name               string
+		status             ResourceStatus
+		event              eventapi.StatusJobEvent
+		failure            *FailedTask
+		expectedChanged    bool
+		expectedConditions map[ConditionType]corev1.ConditionStatus
+		expectedReason     string
+		expectedFailedTask string
+		expectedHistory    []int
+	}{
+		{
+			name:            "first successful run",
+			event:           statsEvent(2, 0, 0),
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionTrue,
+				SuccessfulConditionType: corev1.ConditionTrue,
+			},
+			expectedReason:  SuccessfulReason,
+			expectedHistory: []int{},
+		},
+		{
+			name:   "same successful run",
+			status: successful,
+			event:  statsEvent(2, 0, 0),
+		},
+		{
+			name:            "successful run with other results",
+			status:          successful,
+			event:           statsEvent(3, 1, 0),
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionTrue,
+				SuccessfulConditionType: corev1.ConditionTrue,
+			},
+			expectedReason:  SuccessfulReason,
+			expectedHistory: []int{2},
+		},
+		{
+			name:            "failed task",
+			status:          successful,
+			event:           statsEvent(1, 0, 1),
+			failure:         &FailedTask{Name: "create deployment", Message: "forbidden"},
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionFalse,
+				SuccessfulConditionType: corev1.ConditionFalse,
+				FailureConditionType:    corev1.ConditionTrue,
+			},
+			expectedReason:     FailedReason,
+			expectedFailedTask: "create deployment",
+			expectedHistory:    []int{2},
+		},
+		{
+			name:            "rescued failed task",
+			status:          successful,
+			event:           statsEvent(3, 0, 0),
+			failure:         &FailedTask{Name: "create deployment", Message: "forbidden"},
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionTrue,
+				SuccessfulConditionType: corev1.ConditionTrue,
+			},
+			expectedReason:  SuccessfulReason,
+			expectedHistory: []int{2},
+		},
+		{
+			name:            "failed task with a reason",
+			event:           eventapi.StatusJobEvent{},
+			failure:         &FailedTask{Name: "run", Message: "timed out", Reason: TimeoutReason},
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionFalse,
+				SuccessfulConditionType: corev1.ConditionFalse,
+				FailureConditionType:    corev1.ConditionTrue,
+			},
+			expectedReason:     TimeoutReason,
+			expectedFailedTask: "run",
+			expectedHistory:    []int{},
+		},
+		{
+			name:            "failures without a failed task",
+			event:           statsEvent(1, 0, 1),
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionFalse,
+				SuccessfulConditionType: corev1.ConditionFalse,
+				FailureConditionType:    corev1.ConditionTrue,
+			},
+			expectedReason:  UnknownFailedReason,
+			expectedHistory: []int{},
+		},
+		{
+			name:            "history limit",
+			status:          fullHistory,
+			event:           statsEvent(3, 0, 0),
+			expectedChanged: true,
+			expectedConditions: map[ConditionType]corev1.ConditionStatus{
+				RunningConditionType:    corev1.ConditionTrue,
+				SuccessfulConditionType: corev1.ConditionTrue,
+			},
+			expectedReason:  SuccessfulReason,
+			expectedHistory: []int{101, 102, 103, 104, 105, 106, 107, 108, 109, 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changed, s := UpdateResourceStatus(statusMap(t, tc.status), tc.event, tc.failure)
+			if changed != tc.expectedChanged {
+				t.Fatalf("unexpected changed: %v expected: %v", changed, tc.expectedChanged)
+			}
+			if !changed {
+				return
+			}
+			if len(s.Conditions) != len(tc.expectedConditions) {
+				t.Fatalf("unexpected conditions: %#v", s.Conditions)
+			}
+			for ct, status := range tc.expectedConditions {
+				c := GetCondition(s, ct)
+				if c == nil || c.Status != status {
+					t.Fatalf("unexpected %v condition: %#v expected status: %v", ct, c, status)
+				}
+				if c.Reason != tc.expectedReason {
+					t.Fatalf("unexpected reason of the %v condition: %v expected: %v", ct, c.Reason, tc.expectedReason)
+				}
+			}
+			if c := GetCondition(s, FailureConditionType); c != nil && c.FailedTask != tc.expectedFailedTask {
+				t.Fatalf("unexpected failed task: %v expected: %v", c.FailedTask, tc.expectedFailedTask)
+			}
+			history := []int{}
+			for _, r := range s.History {
+				history = append(history, r.Ok)
+			}
+			if !reflect.DeepEqual(history, tc.expectedHistory) {
+				t.Fatalf("unexpected history: %v expected: %v", history, tc.expectedHistory)
+			}
+		})
+	}
+}
+
+func TestCustomStatusWithEventTypes(t *testing.T) {
+	setFact := eventapi.JobEvent{
+		Event: "runner_on_ok",
+		EventData: map[string]interface{}{
+			"task_action": "set_fact",
+			"res": map[string]interface{}{
+				"ansible_facts": map[string]interface{}{
+					StatusFactName: map[string]interface{}{"endpoint": "memcached:11211"},
+				},
+			},
+		},
+	}
+	for _, f := range []runner.Filter{
+		{Exclude: []string{"runner_on_ok"}},
+		{Include: []string{"runner_on_failed"}},
+	} {
+		if !f.AllowsEvent(setFact) {
+			t.Fatalf("the set_fact task was discarded by %+v", f)
+		}
+		custom := NewCustomStatusFromJobEvent(setFact)
+		if !reflect.DeepEqual(custom, map[string]interface{}{"endpoint": "memcached:11211"}) {
+			t.Fatalf("unexpected custom status: %v", custom)
+		}
+	}
+}
+
+func TestMergeCustomStatus(t *testing.T) {
+	testCases := []struct {
+		name            string
+		status          map[string]interface{}
+		custom          map[string]interface{}
+		expectedChanged bool
+		expectedStatus  map[string]interface{}
+	}{
+		{
+			name:            "new field",
+			status:          map[string]interface{}{},
+			custom:          map[string]interface{}{"endpoint": "memcached:11211"},
+			expectedChanged: true,
+			expectedStatus:  map[string]interface{}{"endpoint": "memcached:11211"},
+		},
+		{
+			name:           "same value with another numeric type",
+			status:         map[string]interface{}{"replicas": int64(3)},
+			custom:         map[string]interface{}{"replicas": float64(3)},
+			expectedStatus: map[string]interface{}{"replicas": int64(3)},
+		},
+		{
+			name:            "changed value",
+			status:          map[string]interface{}{"replicas": int64(3), "endpoint": "memcached:11211"},
+			custom:          map[string]interface{}{"replicas": 4},
+			expectedChanged: true,
+			expectedStatus:  map[string]interface{}{"replicas": 4, "endpoint": "memcached:11211"},
+		},
+		{
+			name:            "null value",
+			status:          map[string]interface{}{},
+			custom:          map[string]interface{}{"endpoint": nil},
+			expectedChanged: true,
+			expectedStatus:  map[string]interface{}{"endpoint": nil},
+		},
+		{
+			name:   "managed fields",
+			status: map[string]interface{}{"observedGeneration": int64(2)},
+			custom: map[string]interface{}{
+				"conditions":                []interface{}{},
+				"history":                   []interface{}{},
+				"observedGeneration":        int64(5),
+				"extraVarsHash":             "hash",
+				runner.LastAppliedSpecField: map[string]interface{}{},
+				"finalizerAttempts":         1,
+			},
+			expectedStatus: map[string]interface{}{"observedGeneration": int64(2)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changed := MergeCustomStatus(tc.status, tc.custom)
+			if changed != tc.expectedChanged {
+				t.Fatalf("unexpected changed: %v expected: %v", changed, tc.expectedChanged)
+			}
+			if !reflect.DeepEqual(tc.status, tc.expectedStatus) {
+				t.Fatalf("unexpected status: %#v expected: %#v", tc.status, tc.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestIsManagedStatusField(t *testing.T) {
+	testCases := []struct {
+		field    string
+		expected bool
+	}{
+		{field: "conditions", expected: true},
+		{field: "history", expected: true},
+		{field: "observedGeneration", expected: true},
+		{field: "extraVarsHash", expected: true},
+		{field: runner.LastAppliedSpecField, expected: true},
+		{field: "finalizerAttempts", expected: true},
+		{field: "endpoint", expected: false},
+		{field: "Conditions", expected: false},
+		{field: "", expected: false},
+	}
+	for _, tc := range testCases {
+		if managed := isManagedStatusField(tc.field); managed != tc.expected {
+			t.Fatalf("unexpected result for %q: %v expected: %v", tc.field, managed, tc.expected)
+		}
+	}
+}
+
+func TestRecordFinalizerAttempt(t *testing.T) {
+	testCases := []struct {
+		name             string
+		status           map[string]interface{}
+		expectedAttempts int
+	}{
+		{
+			name:             "first failure",
+			status:           map[string]interface{}{},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "attempts set by the operator",
+			status:           map[string]interface{}{"finalizerAttempts": int64(2)},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "attempts read from the API server",
+			status:           map[string]interface{}{"finalizerAttempts": float64(4), "endpoint": "memcached:11211"},
+			expectedAttempts: 5,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := RecordFinalizerAttempt(tc.status)
+			if attempts != tc.expectedAttempts {
+				t.Fatalf("unexpected attempts: %v expected: %v", attempts, tc.expectedAttempts)
+			}
+			if tc.status["finalizerAttempts"] != int64(tc.expectedAttempts) {
+				t.Fatalf("unexpected attempts in the status: %#v", tc.status["finalizerAttempts"])
+			}
+			// The attempts are kept when the outcome of the run is recorded.
+			changed, s := UpdateResourceStatus(tc.status, statsEvent(0, 0, 1), &FailedTask{Name: "cleanup", Reason: FinalizerFailedReason})
+			if !changed || s.FinalizerAttempts != tc.expectedAttempts {
+				t.Fatalf("unexpected attempts after the status update: %v expected: %v", s.FinalizerAttempts, tc.expectedAttempts)
+			}
+		})
+	}
+}
+
+func TestSetPaused(t *testing.T) {
+	paused := ResourceStatus{}
+	SetCondition(&paused, *NewCondition(RunningConditionType, corev1.ConditionTrue, nil, SuccessfulReason, SuccessfulMessage))
+	SetCondition(&paused, *NewCondition(PausedConditionType, corev1.ConditionTrue, nil, PausedReason, PausedMessage))
+
+	testCases := []struct {
+		name            string
+		status          ResourceStatus
+		paused          bool
+		expectedChanged bool
+		expectedPaused  bool
+	}{
+		{
+			name:            "pause",
+			paused:          true,
+			expectedChanged: true,
+			expectedPaused:  true,
+		},
+		{
+			name:           "already paused",
+			status:         paused,
+			paused:         true,
+			expectedPaused: true,
+		},
+		{
+			name:            "unpause",
+			status:          paused,
+			expectedChanged: true,
+		},
+		{
+			name: "not paused",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm := statusMap(t, tc.status)
+			changed, err := SetPaused(sm, tc.paused)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Fatalf("unexpected changed: %v expected: %v", changed, tc.expectedChanged)
+			}
+			s := NewStatusFromMap(sm)
+			c := GetCondition(s, PausedConditionType)
+			if (c != nil) != tc.expectedPaused {
+				t.Fatalf("unexpected Paused condition: %#v", c)
+			}
+			if c != nil && (c.Status != corev1.ConditionTrue || c.Reason != PausedReason) {
+				t.Fatalf("unexpected Paused condition: %#v", c)
+			}
+			if len(tc.status.Conditions) > 0 && GetCondition(s, RunningConditionType) == nil {
+				t.Fatalf("the other conditions were removed: %#v", s.Conditions)
+			}
+		})
+	}
+}
+
+func TestNewFailedTaskFromJobEvent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		event    eventapi.JobEvent
+		expected *FailedTask
+	}{
+		{
+			name: "failed task",
+			event: eventapi.JobEvent{
+				Event: "runner_on_failed",
+				EventData: map[string]interface{}{
+					"task":        "create deployment example-memcached",
+					"task_action": "k8s",
+					"res":         map[string]interface{}{"msg": "forbidden"},
+				},
+			},
+			expected: &FailedTask{Name: "create deployment example-memcached", Action: "k8s", Message: "forbidden"},
+		},
+		{
+			name: "ignored failure",
+			event: eventapi.JobEvent{
+				Event: "runner_on_failed",
+				EventData: map[string]interface{}{
+					"task":          "check",
+					"task_action":   "command",
+					"ignore_errors": true,
+				},
+			},
+		},
+		{
+			name: "successful task",
+			event: eventapi.JobEvent{
+				Event:     "runner_on_ok",
+				EventData: map[string]interface{}{"task": "check", "task_action": "command"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFailedTaskFromJobEvent(tc.event)
+			if !reflect.DeepEqual(f, tc.expected) {
+				t.Fatalf("unexpected failed task: %#v expected: %#v", f, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DefaultQueueSize - number of events queued for each handler by default.
+const DefaultQueueSize = 1000
+
+// Dispatcher - delivers the events of the runs to EventHandlers. Each handler
+// has a bounded queue drained by a single goroutine, so it gets the events in
+// order, and a slow handler neither blocks the run nor the other handlers.
+// Events that do not fit in the queue of a handler are dropped for that
+// handler.
+type Dispatcher struct {
+	queues []*handlerQueue
+}
+
+type handlerQueue struct {
+	handler EventHandler
+	name    string
+	events  chan queuedEvent
+	length  prometheus.Gauge
+	dropped prometheus.Counter
+}
+
+type queuedEvent struct {
+	u *unstructured.Unstructured
+	e eventapi.JobEvent
+}
+
+// NewDispatcher - creates a Dispatcher for the handlers of the controller of
+// gvkLabel, with queues of size events, and starts delivering.
+func NewDispatcher(gvkLabel string, handlers []EventHandler, size int) *Dispatcher {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	d := &Dispatcher{}
+	for _, h := range handlers {
+		name := fmt.Sprintf("%T", h)
+		q := &handlerQueue{
+			handler: h,
+			name:    name,
+			events:  make(chan queuedEvent, size),
+			length:  metrics.EventHandlerQueueLength.WithLabelValues(gvkLabel, name),
+			dropped: metrics.EventHandlerDroppedEvents.WithLabelValues(gvkLabel, name),
+		}
+		d.queues = append(d.queues, q)
+		go q.run()
+	}
+	return d
+}
+
+// Dispatch - queues the event for every handler. u is shared by the handlers
+// and must not be modified afterwards, pass a copy of the object of the run.
+func (d *Dispatcher) Dispatch(u *unstructured.Unstructured, e eventapi.JobEvent) {
+	for _, q := range d.queues {
+		// The length is incremented before the send, since the handler may
+		// dequeue the event and decrement it before the send returns.
+		q.length.Inc()
+		select {
+		case q.events <- queuedEvent{u: u, e: e}:
+		default:
+			q.length.Dec()
+			q.dropped.Inc()
+			logrus.Warnf("event queue of %s is full, dropping %s event for %s/%s", q.name, e.Event, u.GetNamespace(), u.GetName())
+		}
+	}
+}
+
+func (q *handlerQueue) run() {
+	for qe := range q.events {
+		q.length.Dec()
+		q.handler.Handle(qe.u, qe.e)
+	}
+}
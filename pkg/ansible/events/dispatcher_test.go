@@ -0,0 +1,134 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// blockingHandler - sends the counter of each event it handles on handled,
+// after release lets it continue.
+type blockingHandler struct {
+	handled chan int
+	release chan struct{}
+}
+
+func (b blockingHandler) Handle(u *unstructured.Unstructured, e eventapi.JobEvent) {
+	b.handled <- e.Counter
+	<-b.release
+}
+
+func receive(t *testing.T, c chan int) int {
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event to be handled")
+	}
+	return 0
+}
+
+// gaugeValue - returns the value of g.
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// lengthHandler - sends the length of its queue when it handles an event.
+type lengthHandler struct {
+	lengths chan float64
+	length  prometheus.Gauge
+}
+
+func (l lengthHandler) Handle(u *unstructured.Unstructured, e eventapi.JobEvent) {
+	m := &dto.Metric{}
+	l.length.Write(m)
+	l.lengths <- m.GetGauge().GetValue()
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	h := blockingHandler{handled: make(chan int, 10), release: make(chan struct{})}
+	close(h.release)
+	d := NewDispatcher("test-order", []EventHandler{h}, 10)
+	u := &unstructured.Unstructured{}
+	for i := 1; i <= 10; i++ {
+		d.Dispatch(u, eventapi.JobEvent{Counter: i})
+	}
+	for i := 1; i <= 10; i++ {
+		if got := receive(t, h.handled); got != i {
+			t.Fatalf("unexpected event %v, expected %v", got, i)
+		}
+	}
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	h := blockingHandler{handled: make(chan int, 10), release: make(chan struct{})}
+	d := NewDispatcher("test-full", []EventHandler{h}, 1)
+	u := &unstructured.Unstructured{}
+
+	// The first event is being handled, the second one is queued and the
+	// third one does not fit.
+	d.Dispatch(u, eventapi.JobEvent{Counter: 1})
+	receive(t, h.handled)
+	d.Dispatch(u, eventapi.JobEvent{Counter: 2})
+	d.Dispatch(u, eventapi.JobEvent{Counter: 3})
+	length := metrics.EventHandlerQueueLength.WithLabelValues("test-full", fmt.Sprintf("%T", h))
+	if l := gaugeValue(t, length); l != 1 {
+		t.Fatalf("unexpected queue length %v, expected 1", l)
+	}
+	close(h.release)
+
+	if got := receive(t, h.handled); got != 2 {
+		t.Fatalf("unexpected event %v, expected 2", got)
+	}
+	select {
+	case got := <-h.handled:
+		t.Fatalf("unexpected event %v, expected it to be dropped", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcherQueueLength(t *testing.T) {
+	h := lengthHandler{lengths: make(chan float64, 100)}
+	h.length = metrics.EventHandlerQueueLength.WithLabelValues("test-length", fmt.Sprintf("%T", h))
+	d := NewDispatcher("test-length", []EventHandler{h}, 100)
+	u := &unstructured.Unstructured{}
+	for i := 1; i <= 100; i++ {
+		d.Dispatch(u, eventapi.JobEvent{Counter: i})
+	}
+	// The length is never negative, even when the handler dequeues an event
+	// before its send returns.
+	for i := 1; i <= 100; i++ {
+		select {
+		case l := <-h.lengths:
+			if l < 0 {
+				t.Fatalf("negative queue length: %v", l)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event to be handled")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// Kubernetes Event reasons
+	ReasonTaskFailed  = "TaskFailed"
+	ReasonRunComplete = "RunComplete"
+	ReasonDebug       = "Debug"
+
+	// eventQPS and eventBurst - rate at which the events of a single CR are
+	// recorded. The summary of a run is always recorded.
+	eventQPS   = 0.1
+	eventBurst = 10
+
+	// maxLimiters and limiterTTL - bound the number of CRs whose rate
+	// limiters are kept.
+	maxLimiters = 4096
+	limiterTTL  = 10 * time.Minute
+)
+
+type kubernetesEventHandler struct {
+	recorder record.EventRecorder
+	debug    bool
+
+	mutex sync.Mutex
+	// limiters - the rate limiter and dropped event count of each CR, by UID.
+	limiters *cache.LRUExpireCache
+}
+
+type limiter struct {
+	flowcontrol.RateLimiter
+	dropped int
+}
+
+// NewKubernetesEventHandler - Creates an Event Handler that records the
+// failed tasks and the summary of the runs as Kubernetes Events on the CR,
+// and the debug messages as well when debug is true. The events of a CR are
+// rate limited, and the recorder aggregates similar events.
+func NewKubernetesEventHandler(recorder record.EventRecorder, debug bool) EventHandler {
+	return &kubernetesEventHandler{
+		recorder: recorder,
+		debug:    debug,
+		limiters: cache.NewLRUExpireCache(maxLimiters),
+	}
+}
+
+func (k *kubernetesEventHandler) Handle(u *unstructured.Unstructured, e eventapi.JobEvent) {
+	switch {
+	case e.Event == EventRunnerOnFailed:
+		if ignore, ok := e.EventData["ignore_errors"].(bool); ok && ignore {
+			return
+		}
+		message := fmt.Sprintf("Task %q failed", e.EventData["task"])
+		if res, ok := e.EventData["res"].(map[string]interface{}); ok {
+			if msg, ok := res["msg"]; ok {
+				message = fmt.Sprintf("%s: %v", message, msg)
+			}
+		}
+		k.record(u, corev1.EventTypeWarning, ReasonTaskFailed, message)
+	case e.Event == EventRunnerOnOk && e.EventData["task_action"] == TaskActionDebug:
+		if !k.debug {
+			return
+		}
+		res, ok := e.EventData["res"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		if msg, ok := res["msg"]; ok {
+			k.record(u, corev1.EventTypeNormal, ReasonDebug, fmt.Sprintf("%v", msg))
+		}
+	case e.Event == EventPlaybookOnStats:
+		message := fmt.Sprintf("Ansible run completed: ok=%d changed=%d skipped=%d failures=%d",
+			sumHosts(e.EventData["ok"]), sumHosts(e.EventData["changed"]),
+			sumHosts(e.EventData["skipped"]), sumHosts(e.EventData["failures"]))
+		if dropped := k.reset(u); dropped > 0 {
+			message = fmt.Sprintf("%s, %d events were not recorded", message, dropped)
+		}
+		k.recorder.Event(u, corev1.EventTypeNormal, ReasonRunComplete, message)
+	}
+}
+
+// record - records the event unless the CR exceeded its rate.
+func (k *kubernetesEventHandler) record(u *unstructured.Unstructured, eventType, reason, message string) {
+	k.mutex.Lock()
+	l := k.limiter(u)
+	accepted := l.TryAccept()
+	if !accepted {
+		l.dropped++
+	}
+	k.mutex.Unlock()
+	if !accepted {
+		logrus.Debugf("dropping %s event for %s/%s: rate limit exceeded", reason, u.GetNamespace(), u.GetName())
+		return
+	}
+	k.recorder.Event(u, eventType, reason, message)
+}
+
+// reset - returns the number of events of the CR dropped since the last call.
+func (k *kubernetesEventHandler) reset(u *unstructured.Unstructured) int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	l := k.limiter(u)
+	dropped := l.dropped
+	l.dropped = 0
+	return dropped
+}
+
+// limiter - returns the limiter of the CR. Must be called with the mutex held.
+func (k *kubernetesEventHandler) limiter(u *unstructured.Unstructured) *limiter {
+	if l, ok := k.limiters.Get(u.GetUID()); ok {
+		return l.(*limiter)
+	}
+	l := &limiter{RateLimiter: flowcontrol.NewTokenBucketRateLimiter(eventQPS, eventBurst)}
+	k.limiters.Add(u.GetUID(), l, limiterTTL)
+	return l
+}
+
+// sumHosts - sums the per host counts of a playbook_on_stats event.
+func sumHosts(counts interface{}) int {
+	m, ok := counts.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	sum := 0
+	for _, v := range m {
+		if n, ok := v.(float64); ok {
+			sum += int(n)
+		}
+	}
+	return sum
+}
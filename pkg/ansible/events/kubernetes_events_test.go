@@ -0,0 +1,132 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestKubernetesEventHandler(t *testing.T) {
+	u := &unstructured.Unstructured{}
+	u.SetName("example")
+	u.SetNamespace("default")
+	u.SetUID("1")
+
+	failed := eventapi.JobEvent{
+		Event: EventRunnerOnFailed,
+		EventData: map[string]interface{}{
+			"task": "create deployment",
+			"res":  map[string]interface{}{"msg": "forbidden"},
+		},
+	}
+	ignored := eventapi.JobEvent{
+		Event: EventRunnerOnFailed,
+		EventData: map[string]interface{}{
+			"task":          "optional",
+			"ignore_errors": true,
+		},
+	}
+	debug := eventapi.JobEvent{
+		Event: EventRunnerOnOk,
+		EventData: map[string]interface{}{
+			"task_action": TaskActionDebug,
+			"res":         map[string]interface{}{"msg": "hello"},
+		},
+	}
+	stats := eventapi.JobEvent{
+		Event: EventPlaybookOnStats,
+		EventData: map[string]interface{}{
+			"ok":       map[string]interface{}{"localhost": float64(3)},
+			"changed":  map[string]interface{}{"localhost": float64(1)},
+			"failures": map[string]interface{}{"localhost": float64(1)},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		debug    bool
+		events   []eventapi.JobEvent
+		expected []string
+	}{
+		{
+			name:   "failure and summary",
+			events: []eventapi.JobEvent{failed, ignored, debug, stats},
+			expected: []string{
+				"Warning TaskFailed Task \"create deployment\" failed: forbidden",
+				"Normal RunComplete Ansible run completed: ok=3 changed=1 skipped=0 failures=1",
+			},
+		},
+		{
+			name:   "debug",
+			debug:  true,
+			events: []eventapi.JobEvent{debug},
+			expected: []string{
+				"Normal Debug hello",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(len(tc.events))
+			h := NewKubernetesEventHandler(recorder, tc.debug)
+			for _, e := range tc.events {
+				h.Handle(u, e)
+			}
+			close(recorder.Events)
+			got := []string{}
+			for e := range recorder.Events {
+				got = append(got, e)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.expected) {
+				t.Fatalf("unexpected events: %q expected: %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestKubernetesEventHandlerRateLimit(t *testing.T) {
+	u := &unstructured.Unstructured{}
+	u.SetName("example")
+	u.SetNamespace("default")
+	u.SetUID("1")
+	failed := eventapi.JobEvent{
+		Event:     EventRunnerOnFailed,
+		EventData: map[string]interface{}{"task": "create deployment"},
+	}
+
+	recorder := record.NewFakeRecorder(2 * eventBurst)
+	h := NewKubernetesEventHandler(recorder, false)
+	for i := 0; i < eventBurst+5; i++ {
+		h.Handle(u, failed)
+	}
+	h.Handle(u, eventapi.JobEvent{Event: EventPlaybookOnStats, EventData: map[string]interface{}{}})
+	close(recorder.Events)
+	got := []string{}
+	for e := range recorder.Events {
+		got = append(got, e)
+	}
+	if len(got) != eventBurst+1 {
+		t.Fatalf("expected %v events, got %v: %q", eventBurst+1, len(got), got)
+	}
+	expected := "Normal RunComplete Ansible run completed: ok=0 changed=0 skipped=0 failures=0, 5 events were not recorded"
+	if got[len(got)-1] != expected {
+		t.Fatalf("unexpected summary: %q expected: %q", got[len(got)-1], expected)
+	}
+}
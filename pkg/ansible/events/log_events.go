@@ -15,6 +15,8 @@
 package events
 
 import (
+	"fmt"
+
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -37,12 +39,26 @@ const (
 	EventPlaybookOnTaskStart = "playbook_on_task_start"
 	EventRunnerOnOk          = "runner_on_ok"
 	EventRunnerOnFailed      = "runner_on_failed"
+	EventPlaybookOnStats     = "playbook_on_stats"
 
 	// Ansible Task Actions
 	TaskActionSetFact = "set_fact"
 	TaskActionDebug   = "debug"
 )
 
+// LogFormat - format of the logs.
+type LogFormat string
+
+const (
+	// TextFormat - log lines of key=value pairs.
+	TextFormat LogFormat = "text"
+	// JSONFormat - log one JSON object per line.
+	JSONFormat LogFormat = "json"
+
+	// NoLogMessage - replaces the values hidden by no_log in the logs.
+	NoLogMessage = "the output has been hidden due to the fact that 'no_log: true' was specified for this result"
+)
+
 // EventHandler - knows how to handle job events.
 type EventHandler interface {
 	Handle(*unstructured.Unstructured, eventapi.JobEvent)
@@ -53,16 +69,11 @@ type loggingEventHandler struct {
 }
 
 func (l loggingEventHandler) Handle(u *unstructured.Unstructured, e eventapi.JobEvent) {
-	log := logrus.WithFields(logrus.Fields{
-		"component":  "logging_event_handler",
-		"name":       u.GetName(),
-		"namespace":  u.GetNamespace(),
-		"gvk":        u.GroupVersionKind().String(),
-		"event_type": e.Event,
-	})
 	if l.LogLevel == Nothing {
 		return
 	}
+	noLog := isNoLog(e)
+	log := logrus.WithFields(eventFields(u, e, noLog))
 	// log only the following for the 'Tasks' LogLevel
 	t, ok := e.EventData["task"]
 	if ok {
@@ -74,19 +85,103 @@ func (l loggingEventHandler) Handle(u *unstructured.Unstructured, e eventapi.Job
 			return
 		}
 		if e.Event == EventRunnerOnOk && debugAction {
-			log.Infof("[playbook debug]: %v", e.EventData["task_args"])
+			log.Infof("[playbook debug]: %v", redact(e.EventData["task_args"], noLog))
 			return
 		}
 		if e.Event == EventRunnerOnFailed {
 			log.Errorf("[failed]: [playbook task] '%s' failed with task_args - %v",
-				t, e.EventData["task_args"])
+				t, redact(e.EventData["task_args"], noLog))
 			return
 		}
 	}
 	// log everything else for the 'Everything' LogLevel
 	if l.LogLevel == Everything {
-		log.Infof("event: %#v", e.EventData)
+		log.Infof("event: %#v", redactEventData(e.EventData, noLog))
+	}
+}
+
+// eventFields - the fields logged with every event. Their names are stable,
+// so that the JSON output can be indexed.
+func eventFields(u *unstructured.Unstructured, e eventapi.JobEvent, noLog bool) logrus.Fields {
+	fields := logrus.Fields{
+		"component":  "logging_event_handler",
+		"job":        e.RunnerIdent,
+		"name":       u.GetName(),
+		"namespace":  u.GetNamespace(),
+		"gvk":        u.GroupVersionKind().String(),
+		"event_type": e.Event,
+	}
+	for _, k := range []string{"task", "task_action", "host", "duration"} {
+		if v, ok := e.EventData[k]; ok {
+			fields[k] = v
+		}
+	}
+	if res, ok := e.EventData["res"].(map[string]interface{}); ok {
+		if v, ok := res["changed"]; ok {
+			fields["changed"] = v
+		}
+		if v, ok := res["msg"]; ok {
+			fields["result_msg"] = redact(v, noLog)
+		}
+	}
+	switch e.Event {
+	case EventRunnerOnFailed:
+		fields["failed"] = true
+	case EventRunnerOnOk:
+		fields["failed"] = false
+	}
+	return fields
+}
+
+// isNoLog - whether the result of the event was hidden with no_log.
+func isNoLog(e eventapi.JobEvent) bool {
+	res, ok := e.EventData["res"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if noLog, ok := res["_ansible_no_log"].(bool); ok && noLog {
+		return true
+	}
+	_, censored := res["censored"]
+	return censored
+}
+
+// redact - replaces v with NoLogMessage when noLog is true.
+func redact(v interface{}, noLog bool) interface{} {
+	if noLog {
+		return NoLogMessage
+	}
+	return v
+}
+
+// redactEventData - returns the event data, with the task arguments and the
+// result redacted when noLog is true.
+func redactEventData(data map[string]interface{}, noLog bool) map[string]interface{} {
+	if !noLog {
+		return data
+	}
+	redacted := map[string]interface{}{}
+	for k, v := range data {
+		switch k {
+		case "res", "task_args":
+			redacted[k] = NoLogMessage
+		default:
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+// NewLogFormatter - returns the logrus formatter that writes logs in the
+// given format.
+func NewLogFormatter(f LogFormat) (logrus.Formatter, error) {
+	switch f {
+	case TextFormat:
+		return &logrus.TextFormatter{}, nil
+	case JSONFormat:
+		return &logrus.JSONFormatter{}, nil
 	}
+	return nil, fmt.Errorf("unknown log format %q, must be one of %q or %q", f, TextFormat, JSONFormat)
 }
 
 // NewLoggingEventHandler - Creates a Logging Event Handler to log events.
@@ -0,0 +1,105 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestLoggingEventHandlerJSON(t *testing.T) {
+	formatter, err := NewLogFormatter(JSONFormat)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	logrus.SetFormatter(formatter)
+	logrus.SetOutput(buf)
+	defer func() {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+		logrus.SetOutput(os.Stderr)
+	}()
+
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("app.example.com/v1alpha1")
+	u.SetKind("Database")
+	u.SetName("example")
+	u.SetNamespace("default")
+
+	h := NewLoggingEventHandler(Tasks)
+	h.Handle(u, eventapi.JobEvent{
+		Event:       EventRunnerOnFailed,
+		RunnerIdent: "1234",
+		EventData: map[string]interface{}{
+			"task":        "create user",
+			"task_action": "k8s",
+			"task_args":   "password=secret",
+			"host":        "localhost",
+			"duration":    1.5,
+			"res": map[string]interface{}{
+				"changed":         false,
+				"msg":             "password=secret",
+				"_ansible_no_log": true,
+			},
+		},
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 log line, got %v: %s", len(lines), buf.String())
+	}
+	if strings.Contains(lines[0], "secret") {
+		t.Fatalf("no_log result was not redacted: %s", lines[0])
+	}
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, lines[0])
+	}
+	expected := map[string]interface{}{
+		"job":         "1234",
+		"name":        "example",
+		"namespace":   "default",
+		"gvk":         "app.example.com/v1alpha1, Kind=Database",
+		"event_type":  EventRunnerOnFailed,
+		"task":        "create user",
+		"task_action": "k8s",
+		"host":        "localhost",
+		"duration":    1.5,
+		"changed":     false,
+		"failed":      true,
+		"result_msg":  NoLogMessage,
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("unexpected %s: %#v expected: %#v", k, entry[k], v)
+		}
+	}
+}
+
+func TestNewLogFormatter(t *testing.T) {
+	if _, err := NewLogFormatter(TextFormat); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewLogFormatter("xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
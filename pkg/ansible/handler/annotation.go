@@ -0,0 +1,81 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// NamespacedNameAnnotation - the namespace/name of the primary resource
+	// of a dependent resource that cannot have an owner reference to it,
+	// such as a cluster scoped resource or a resource in another namespace.
+	NamespacedNameAnnotation = "operator-sdk/primary-resource"
+	// TypeAnnotation - the Kind.group of the primary resource named by
+	// NamespacedNameAnnotation.
+	TypeAnnotation = "operator-sdk/primary-resource-type"
+)
+
+// SetOwnerAnnotations - sets the annotations of obj naming owner, of the
+// ownerType kind, as its primary resource.
+func SetOwnerAnnotations(obj metav1.Object, ownerType schema.GroupKind, owner types.NamespacedName) {
+	a := obj.GetAnnotations()
+	if a == nil {
+		a = map[string]string{}
+	}
+	a[NamespacedNameAnnotation] = owner.String()
+	a[TypeAnnotation] = ownerType.String()
+	obj.SetAnnotations(a)
+}
+
+// OwnerFromAnnotations - returns the primary resource named by the
+// annotations of obj, false when obj is not annotated with a primary
+// resource of the ownerType kind.
+func OwnerFromAnnotations(obj metav1.Object, ownerType schema.GroupKind) (types.NamespacedName, bool) {
+	a := obj.GetAnnotations()
+	nn, ok := a[NamespacedNameAnnotation]
+	if !ok || a[TypeAnnotation] != ownerType.String() {
+		return types.NamespacedName{}, false
+	}
+	// Cluster scoped primary resources have no namespace.
+	if i := strings.LastIndex(nn, "/"); i >= 0 {
+		return types.NamespacedName{Namespace: nn[:i], Name: nn[i+1:]}, nn[i+1:] != ""
+	}
+	return types.NamespacedName{Name: nn}, nn != ""
+}
+
+// EnqueueRequestForAnnotation - returns an event handler enqueueing the
+// primary resources of the ownerType kind named by the annotations of the
+// objects.
+func EnqueueRequestForAnnotation(ownerType schema.GroupKind) crthandler.EventHandler {
+	return &crthandler.EnqueueRequestsFromMapFunc{
+		ToRequests: crthandler.ToRequestsFunc(func(o crthandler.MapObject) []reconcile.Request {
+			if o.Meta == nil {
+				return nil
+			}
+			owner, ok := OwnerFromAnnotations(o.Meta, ownerType)
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: owner}}
+		}),
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEnqueueRequestForAnnotation(t *testing.T) {
+	ownerType := schema.GroupKind{Group: "cache.example.com", Kind: "Memcached"}
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    []reconcile.Request
+	}{
+		{
+			name:        "namespaced owner",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/example", TypeAnnotation: "Memcached.cache.example.com"},
+			expected:    []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "example"}}},
+		},
+		{
+			name:        "cluster scoped owner",
+			annotations: map[string]string{NamespacedNameAnnotation: "/example", TypeAnnotation: "Memcached.cache.example.com"},
+			expected:    []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "example"}}},
+		},
+		{
+			name:        "owner of another type",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/example", TypeAnnotation: "Memcached.other.example.com"},
+		},
+		{
+			name:        "no name",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/", TypeAnnotation: "Memcached.cache.example.com"},
+		},
+		{
+			name: "not annotated",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetName("dependent")
+			u.SetAnnotations(tc.annotations)
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+			EnqueueRequestForAnnotation(ownerType).Create(event.CreateEvent{Meta: u, Object: u}, q)
+			if q.Len() != len(tc.expected) {
+				t.Fatalf("expected %v requests, got: %v", len(tc.expected), q.Len())
+			}
+			for _, expected := range tc.expected {
+				r, _ := q.Get()
+				if r != expected {
+					t.Fatalf("expected %v, got: %v", expected, r)
+				}
+			}
+		})
+	}
+}
+
+func TestSetOwnerAnnotations(t *testing.T) {
+	ownerType := schema.GroupKind{Group: "cache.example.com", Kind: "Memcached"}
+	owner := types.NamespacedName{Namespace: "default", Name: "example"}
+	u := &unstructured.Unstructured{}
+	u.SetAnnotations(map[string]string{"app": "memcached"})
+	SetOwnerAnnotations(u, ownerType, owner)
+	if u.GetAnnotations()["app"] != "memcached" {
+		t.Fatalf("the annotations of the resource were not kept: %v", u.GetAnnotations())
+	}
+	o, ok := OwnerFromAnnotations(u, ownerType)
+	if !ok || o != owner {
+		t.Fatalf("expected the owner %v, got: %v", owner, o)
+	}
+}
+
+func TestOwnerFromAnnotations(t *testing.T) {
+	ownerType := schema.GroupKind{Group: "cache.example.com", Kind: "Memcached"}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		owner       types.NamespacedName
+		ok          bool
+	}{
+		{
+			name:        "namespaced owner",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/example", TypeAnnotation: "Memcached.cache.example.com"},
+			owner:       types.NamespacedName{Namespace: "default", Name: "example"},
+			ok:          true,
+		},
+		{
+			name:        "name without namespace",
+			annotations: map[string]string{NamespacedNameAnnotation: "example", TypeAnnotation: "Memcached.cache.example.com"},
+			owner:       types.NamespacedName{Name: "example"},
+			ok:          true,
+		},
+		{
+			name:        "type missing",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/example"},
+		},
+		{
+			name:        "type of another group",
+			annotations: map[string]string{NamespacedNameAnnotation: "default/example", TypeAnnotation: "Memcached"},
+		},
+		{
+			name:        "empty name",
+			annotations: map[string]string{NamespacedNameAnnotation: "", TypeAnnotation: "Memcached.cache.example.com"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{}
+			u.SetAnnotations(tc.annotations)
+			owner, ok := OwnerFromAnnotations(u, ownerType)
+			if ok != tc.ok || owner != tc.owner {
+				t.Fatalf("expected %v %v, got: %v %v", tc.owner, tc.ok, owner, ok)
+			}
+		})
+	}
+}
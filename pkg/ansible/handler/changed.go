@@ -0,0 +1,126 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+// ChangedOwners - the primary resources enqueued because one of their
+// dependent resources changed, so that their reconciliation is not skipped
+// when they did not change themselves. A nil ChangedOwners records nothing.
+type ChangedOwners struct {
+	mutex  sync.Mutex
+	owners map[types.NamespacedName]bool
+}
+
+// NewChangedOwners - creates an empty ChangedOwners.
+func NewChangedOwners() *ChangedOwners {
+	return &ChangedOwners{owners: map[types.NamespacedName]bool{}}
+}
+
+// Take - returns true when a dependent resource of owner changed since the
+// last call, and forgets it.
+func (c *ChangedOwners) Take(owner types.NamespacedName) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	changed := c.owners[owner]
+	delete(c.owners, owner)
+	return changed
+}
+
+// record - records the owner of a request.
+func (c *ChangedOwners) record(item interface{}) {
+	r, ok := item.(reconcile.Request)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.owners[r.NamespacedName] = true
+}
+
+// RecordChanges - returns an event handler enqueueing the requests of h for
+// the events of dependent resources, and recording their owners in c.
+func RecordChanges(h crthandler.EventHandler, c *ChangedOwners) crthandler.EventHandler {
+	if c == nil {
+		return h
+	}
+	return &recordingHandler{handler: h, changed: c}
+}
+
+// recordingHandler - the event handler returned by RecordChanges.
+type recordingHandler struct {
+	handler crthandler.EventHandler
+	changed *ChangedOwners
+}
+
+func (h *recordingHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Create(e, h.queue(q))
+}
+
+func (h *recordingHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Update(e, h.queue(q))
+}
+
+func (h *recordingHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Delete(e, h.queue(q))
+}
+
+func (h *recordingHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Generic(e, h.queue(q))
+}
+
+// InjectFunc - injects the dependencies of the controller, such as the scheme
+// needed by EnqueueRequestForOwner, into the wrapped handler.
+func (h *recordingHandler) InjectFunc(f inject.Func) error {
+	return f(h.handler)
+}
+
+func (h *recordingHandler) queue(q workqueue.RateLimitingInterface) workqueue.RateLimitingInterface {
+	return &recordingQueue{RateLimitingInterface: q, changed: h.changed}
+}
+
+// recordingQueue - records the owners of the requests added to the queue.
+type recordingQueue struct {
+	workqueue.RateLimitingInterface
+	changed *ChangedOwners
+}
+
+func (q *recordingQueue) Add(item interface{}) {
+	q.changed.record(item)
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *recordingQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.changed.record(item)
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *recordingQueue) AddRateLimited(item interface{}) {
+	q.changed.record(item)
+	q.RateLimitingInterface.AddRateLimited(item)
+}
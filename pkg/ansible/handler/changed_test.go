@@ -0,0 +1,102 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+func TestRecordChanges(t *testing.T) {
+	ownerType := schema.GroupKind{Group: "cache.example.com", Kind: "Memcached"}
+	owner := types.NamespacedName{Namespace: "default", Name: "example"}
+	annotated := &unstructured.Unstructured{}
+	annotated.SetName("annotated")
+	SetOwnerAnnotations(annotated, ownerType, owner)
+	referenced := &unstructured.Unstructured{}
+	referenced.SetName("referenced")
+	referenced.SetNamespace(owner.Namespace)
+	isController := true
+	referenced.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "cache.example.com/v1alpha1", Kind: "Memcached", Name: owner.Name, Controller: &isController}})
+	ownerObject := &unstructured.Unstructured{}
+	ownerObject.SetGroupVersionKind(ownerType.WithVersion("v1alpha1"))
+
+	testCases := []struct {
+		name      string
+		handler   crthandler.EventHandler
+		dependent *unstructured.Unstructured
+	}{
+		{
+			name:      "owner annotations",
+			handler:   EnqueueRequestForAnnotation(ownerType),
+			dependent: annotated,
+		},
+		{
+			name:      "owner reference",
+			handler:   &crthandler.EnqueueRequestForOwner{OwnerType: ownerObject},
+			dependent: referenced,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changed := NewChangedOwners()
+			h := RecordChanges(tc.handler, changed)
+			// The controller injects its scheme through the wrapper.
+			_, err := inject.InjectorInto(func(i interface{}) error {
+				_, err := inject.SchemeInto(scheme.Scheme, i)
+				return err
+			}, h)
+			if err != nil {
+				t.Fatalf("unable to inject the scheme: %v", err)
+			}
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+			if changed.Take(owner) {
+				t.Fatalf("unexpected change of the dependent resources of %v", owner)
+			}
+			h.Update(event.UpdateEvent{MetaOld: tc.dependent, ObjectOld: tc.dependent, MetaNew: tc.dependent, ObjectNew: tc.dependent}, q)
+			if q.Len() != 1 {
+				t.Fatalf("expected 1 request, got: %v", q.Len())
+			}
+			if !changed.Take(owner) {
+				t.Fatalf("the change of the dependent resources of %v was not recorded", owner)
+			}
+			if changed.Take(owner) {
+				t.Fatalf("the change of the dependent resources of %v was not forgotten", owner)
+			}
+		})
+	}
+}
+
+func TestRecordChangesNil(t *testing.T) {
+	h := EnqueueRequestForAnnotation(schema.GroupKind{Group: "cache.example.com", Kind: "Memcached"})
+	if RecordChanges(h, nil) != h {
+		t.Fatalf("expected the handler to be returned unchanged")
+	}
+	var changed *ChangedOwners
+	if changed.Take(types.NamespacedName{Namespace: "default", Name: "example"}) {
+		t.Fatalf("unexpected change recorded by a nil ChangedOwners")
+	}
+}
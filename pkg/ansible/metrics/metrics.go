@@ -0,0 +1,127 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/client/monitoring/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	namespace = "ansible_operator"
+
+	// RunTypeReconcile and RunTypeFinalizer - values of the run_type label.
+	RunTypeReconcile = "reconcile"
+	RunTypeFinalizer = "finalizer"
+
+	// OutcomeSuccessful, OutcomeFailed and OutcomeTimeout - values of the
+	// outcome label.
+	OutcomeSuccessful = "successful"
+	OutcomeFailed     = "failed"
+	OutcomeTimeout    = "timeout"
+)
+
+var (
+	// RunDuration - duration of the runs of ansible-runner.
+	RunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the runs of ansible-runner.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"gvk", "run_type"})
+
+	// Runs - runs of ansible-runner by outcome.
+	Runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Runs of ansible-runner by outcome.",
+	}, []string{"gvk", "run_type", "outcome"})
+
+	// TaskFailures - failed tasks of the runs, by module. Task names are not
+	// used as labels, since they can hold variables and are not bounded.
+	TaskFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_failures_total",
+		Help:      "Failed tasks of the runs of ansible-runner, by module.",
+	}, []string{"gvk", "action"})
+
+	// RunsInFlight - runs of ansible-runner in progress.
+	RunsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "runs_in_flight",
+		Help:      "Runs of ansible-runner in progress.",
+	}, []string{"gvk"})
+
+	// DroppedEvents - events sent by ansible-runner that the event API could
+	// not pass on.
+	DroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_events_total",
+		Help:      "Events sent by ansible-runner that were dropped by the event API.",
+	}, []string{"gvk"})
+
+	// EventHandlerQueueLength - events waiting to be handled, by handler.
+	EventHandlerQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_handler_queue_length",
+		Help:      "Events of the runs waiting to be handled, by handler.",
+	}, []string{"gvk", "handler"})
+
+	// EventHandlerDroppedEvents - events not handled because the queue of the
+	// handler was full.
+	EventHandlerDroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_handler_dropped_events_total",
+		Help:      "Events of the runs dropped because the queue of the handler was full.",
+	}, []string{"gvk", "handler"})
+)
+
+func init() {
+	prometheus.MustRegister(RunDuration, Runs, TaskFailures, RunsInFlight, DroppedEvents,
+		EventHandlerQueueLength, EventHandlerDroppedEvents)
+}
+
+// GVKLabel - the value of the gvk label for a GVK.
+func GVKLabel(gvk schema.GroupVersionKind) string {
+	return gvk.String()
+}
+
+// Expose - serves the metrics on the metrics port, behind the operator
+// Service, and creates a ServiceMonitor for that Service so that a
+// prometheus-operator scrapes it. Failures are logged, since the operator can
+// run without metrics.
+func Expose(cfg *rest.Config) {
+	service := sdk.ExposeMetricsPort()
+	if service == nil {
+		return
+	}
+	mclient, err := monitoringv1.NewForConfig(&monitoringv1.DefaultCrdKinds, monitoringv1.Group, cfg)
+	if err != nil {
+		logrus.Errorf("failed to create the ServiceMonitor client: %v", err)
+		return
+	}
+	_, err = mclient.ServiceMonitors(service.Namespace).Create(sdk.GenerateServiceMonitor(service))
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		logrus.Warnf("failed to create the ServiceMonitor for operator metrics, is the prometheus-operator installed? %v", err)
+		return
+	}
+	logrus.Infof("ServiceMonitor %s created", service.Name)
+}
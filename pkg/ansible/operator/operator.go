@@ -16,9 +16,14 @@ package operator
 
 import (
 	"math/rand"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/operator-framework/operator-sdk/pkg/ansible/controller"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
@@ -26,10 +31,34 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// MaxWorkersEnvVar - env var used to set the default number of workers of
+// each controller, when it is not set in the watches file.
+const MaxWorkersEnvVar = "MAX_WORKERS"
+
+// DefaultMaxWorkers - returns the number of workers set by MaxWorkersEnvVar,
+// or 1 if it is not set or is invalid.
+func DefaultMaxWorkers() int {
+	v, ok := os.LookupEnv(MaxWorkersEnvVar)
+	if !ok {
+		return 1
+	}
+	maxWorkers, err := strconv.Atoi(v)
+	if err != nil || maxWorkers < 1 {
+		logrus.Warnf("invalid value %q for %s, defaulting to 1", v, MaxWorkersEnvVar)
+		return 1
+	}
+	return maxWorkers
+}
+
 // Run - A blocking function which starts a controller-runtime manager
 // It starts an Operator by reading in the values in `./watches.yaml`, adds a controller
-// to the manager, and finally running the manager.
-func Run(done chan error, mgr manager.Manager, watchesPath string, reconcilePeriod time.Duration) {
+// to the manager, and finally running the manager. maxWorkers is used for the
+// watches that do not set maxWorkers, and kubernetesEvents for the ones that
+// do not set kubernetesEvents. The controllers are stored in cMap, so that the
+// proxy can add watches for their dependent resources, and the tokens of the
+// runs in tokens, so that the proxy can authenticate them. The runs connect to
+// the proxy at proxyURL, as returned by proxy.Run.
+func Run(done chan error, mgr manager.Manager, watchesPath string, reconcilePeriod time.Duration, maxWorkers int, kubernetesEvents bool, cMap *controllermap.ControllerMap, tokens *kubeconfig.Tokens, proxyURL string) {
 	watches, err := runner.NewFromWatches(watchesPath)
 	if err != nil {
 		logrus.Error("Failed to get watches")
@@ -44,12 +73,39 @@ func Run(done chan error, mgr manager.Manager, watchesPath string, reconcilePeri
 			GVK:             gvk,
 			Runner:          runner,
 			ReconcilePeriod: reconcilePeriod,
+			SkipUnchanged:   runner.GetSkipUnchanged(),
+			MaxWorkers:      maxWorkers,
+
+			KubernetesEvents:      kubernetesEvents,
+			KubernetesDebugEvents: runner.GetKubernetesDebugEvents(),
+			Selector:              runner.GetSelector(),
+			Tokens:                tokens,
+			ProxyURL:              proxyURL,
 		}
 		d, ok := runner.GetReconcilePeriod()
 		if ok {
 			o.ReconcilePeriod = d
 		}
-		controller.Add(mgr, o)
+		w, ok := runner.GetMaxWorkers()
+		if ok {
+			o.MaxWorkers = w
+		}
+		e, ok := runner.GetKubernetesEvents()
+		if ok {
+			o.KubernetesEvents = e
+		}
+		// The runs of the resources whose dependent resources changed are
+		// not skipped.
+		if o.SkipUnchanged {
+			o.ChangedOwners = handler.NewChangedOwners()
+		}
+		ctr := controller.Add(mgr, o)
+		cMap.Store(gvk, &controllermap.Contents{
+			Controller:              ctr,
+			WatchDependentResources: runner.GetWatchDependentResources(),
+			AccessRules:             runner.GetAccessRules(),
+			ChangedOwners:           o.ChangedOwners,
+		})
 	}
 	done <- mgr.Start(c)
 }
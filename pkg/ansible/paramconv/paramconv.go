@@ -17,28 +17,66 @@
 package paramconv
 
 import (
-	"regexp"
 	"strings"
+	"sync"
 )
 
 var (
-	numberSequence    = regexp.MustCompile(`([a-zA-Z])(\d+)([a-zA-Z]?)`)
-	numberReplacement = []byte(`$1 $2 $3`)
-	wordMapping       = map[string]string{
-		"http": "HTTP",
-		"url":  "URL",
-		"ip":   "IP",
-	}
+	// DefaultAcronyms - words written in upper case in camelCase by every
+	// Converter.
+	DefaultAcronyms = []string{"HTTP", "URL", "IP"}
+
+	defaultConverter = NewConverter()
 )
 
-func addWordBoundariesToNumbers(s string) string {
-	b := []byte(s)
-	b = numberSequence.ReplaceAll(b, numberReplacement)
-	return string(b)
+// Converter - converts names between camelCase and snake_case. Names made of
+// words in Title case, digits and acronyms of the Converter convert back to
+// themselves:
+//
+//	ToCamel(ToSnake(name)) == name
+//
+// Digits stay in the word they follow, so http2Enabled becomes http2_enabled.
+// A name starting with an upper case letter gets a leading underscore in
+// snake_case, unless it starts with an acronym. Acronyms are written in upper
+// case in camelCase, including at the start of a name, so http_port becomes
+// HTTPPort. The names that would not convert back to themselves, such as
+// httpPort, whose acronym is in lower case, are remembered by ToSnake, so that
+// ToCamel converts their snake_case back to the last one of them.
+type Converter struct {
+	// acronyms - upper case acronyms by their lower case form.
+	acronyms map[string]string
+
+	mutex sync.Mutex
+	// originals - the names converted by ToSnake that would not convert
+	// back to themselves, by their snake_case.
+	originals map[string]string
+}
+
+// NewConverter - returns a Converter for the DefaultAcronyms and acronyms.
+func NewConverter(acronyms ...string) *Converter {
+	c := &Converter{acronyms: map[string]string{}, originals: map[string]string{}}
+	for _, a := range DefaultAcronyms {
+		c.acronyms[strings.ToLower(a)] = a
+	}
+	for _, a := range acronyms {
+		c.acronyms[strings.ToLower(a)] = strings.ToUpper(a)
+	}
+	return c
+}
+
+// acronym - returns word in upper case when it is an acronym of the
+// Converter, followed or not by digits.
+func (c *Converter) acronym(word string) (string, bool) {
+	letters := strings.TrimRight(word, "0123456789")
+	val, ok := c.acronyms[letters]
+	if !ok {
+		return "", false
+	}
+	return val + word[len(letters):], true
 }
 
-func translateWord(word string, initCase bool) string {
-	if val, ok := wordMapping[word]; ok {
+func (c *Converter) translateWord(word string, initCase bool) string {
+	if val, ok := c.acronym(word); ok {
 		return val
 	}
 	if initCase {
@@ -47,9 +85,18 @@ func translateWord(word string, initCase bool) string {
 	return word
 }
 
-// Converts a string to CamelCase
-func ToCamel(s string) string {
-	s = addWordBoundariesToNumbers(s)
+// ToCamel - converts a string to camelCase
+func (c *Converter) ToCamel(s string) string {
+	c.mutex.Lock()
+	original, ok := c.originals[s]
+	c.mutex.Unlock()
+	if ok {
+		return original
+	}
+	return c.toCamel(s)
+}
+
+func (c *Converter) toCamel(s string) string {
 	s = strings.Trim(s, " ")
 	n := ""
 	bits := []string{}
@@ -65,15 +112,29 @@ func ToCamel(s string) string {
 
 	ret := ""
 	for i, substr := range bits {
-		ret += translateWord(substr, i != 0)
+		ret += c.translateWord(substr, i != 0)
 	}
 	return ret
 }
 
-// Converts a string to snake_case
-func ToSnake(s string) string {
-	s = addWordBoundariesToNumbers(s)
+// ToSnake - converts a string to snake_case
+func (c *Converter) ToSnake(s string) string {
 	s = strings.Trim(s, " ")
+	if s == "" {
+		return s
+	}
+	snake := c.toSnake(s)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.toCamel(snake) != s {
+		c.originals[snake] = s
+	} else {
+		delete(c.originals, snake)
+	}
+	return snake
+}
+
+func (c *Converter) toSnake(s string) string {
 	var prefix string
 	char1 := []rune(s)[0]
 	if char1 >= 'A' && char1 <= 'Z' {
@@ -118,12 +179,24 @@ func ToSnake(s string) string {
 	}
 	bits = append(bits, strings.ToLower(n))
 	joined := strings.Join(bits, "_")
-	if _, ok := wordMapping[bits[0]]; !ok {
+	if _, ok := c.acronym(bits[0]); !ok {
 		return prefix + joined
 	}
 	return joined
 }
 
+// MapToSnake - converts the keys of in, and of the maps it holds, to
+// snake_case.
+func (c *Converter) MapToSnake(in map[string]interface{}) map[string]interface{} {
+	return convertMapKeys(c.ToSnake, in)
+}
+
+// MapToCamel - converts the keys of in, and of the maps it holds, to
+// camelCase.
+func (c *Converter) MapToCamel(in map[string]interface{}) map[string]interface{} {
+	return convertMapKeys(c.ToCamel, in)
+}
+
 func convertParameter(fn func(string) string, v interface{}) interface{} {
 	switch v := v.(type) {
 	case map[string]interface{}:
@@ -155,10 +228,20 @@ func convertMapKeys(fn func(string) string, in map[string]interface{}) map[strin
 	return converted
 }
 
+// Converts a string to CamelCase
+func ToCamel(s string) string {
+	return defaultConverter.ToCamel(s)
+}
+
+// Converts a string to snake_case
+func ToSnake(s string) string {
+	return defaultConverter.ToSnake(s)
+}
+
 func MapToSnake(in map[string]interface{}) map[string]interface{} {
-	return convertMapKeys(ToSnake, in)
+	return defaultConverter.MapToSnake(in)
 }
 
 func MapToCamel(in map[string]interface{}) map[string]interface{} {
-	return convertMapKeys(ToCamel, in)
+	return defaultConverter.MapToCamel(in)
 }
@@ -0,0 +1,161 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paramconv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := NewConverter("ARN", "id")
+	testCases := []struct {
+		name  string
+		snake string
+	}{
+		{name: "size", snake: "size"},
+		{name: "serviceAccount", snake: "service_account"},
+		{name: "ServiceAccount", snake: "_service_account"},
+		{name: "http2Enabled", snake: "http2_enabled"},
+		{name: "HTTP2Enabled", snake: "http2_enabled"},
+		{name: "httpPort", snake: "http_port"},
+		{name: "HTTPPort", snake: "http_port"},
+		{name: "ipAddress", snake: "ip_address"},
+		{name: "IPAddress", snake: "ip_address"},
+		{name: "url", snake: "url"},
+		{name: "URL", snake: "url"},
+		{name: "Http", snake: "http"},
+		{name: "podIP", snake: "pod_ip"},
+		{name: "baseURLPath", snake: "base_url_path"},
+		{name: "s3BucketARN", snake: "s3_bucket_arn"},
+		{name: "bucketARN", snake: "bucket_arn"},
+		{name: "replicas2", snake: "replicas2"},
+		{name: "ipv4Address", snake: "ipv4_address"},
+		{name: "volumeID", snake: "volume_id"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snake := c.ToSnake(tc.name)
+			if snake != tc.snake {
+				t.Fatalf("unexpected snake_case: %v expected: %v", snake, tc.snake)
+			}
+			if camel := c.ToCamel(snake); camel != tc.name {
+				t.Fatalf("unexpected camelCase: %v expected: %v", camel, tc.name)
+			}
+		})
+	}
+}
+
+func TestAcronyms(t *testing.T) {
+	if camel := ToCamel("bucket_arn"); camel != "bucketArn" {
+		t.Fatalf("unexpected camelCase: %v expected: bucketArn", camel)
+	}
+	if camel := NewConverter("arn").ToCamel("bucket_arn"); camel != "bucketARN" {
+		t.Fatalf("unexpected camelCase: %v expected: bucketARN", camel)
+	}
+	if camel := NewConverter("arn").ToCamel("arn_id"); camel != "ARNId" {
+		t.Fatalf("unexpected camelCase: %v expected: ARNId", camel)
+	}
+}
+
+// TestDefaultConversion checks that the default conversion keeps the results
+// of the names starting with an acronym, which the roles rely on.
+func TestDefaultConversion(t *testing.T) {
+	snakeCases := []struct {
+		name  string
+		snake string
+	}{
+		{name: "URL", snake: "url"},
+		{name: "IPAddress", snake: "ip_address"},
+		{name: "HTTPPort", snake: "http_port"},
+		{name: "Http", snake: "http"},
+		{name: "httpPort", snake: "http_port"},
+		{name: "ServiceAccount", snake: "_service_account"},
+	}
+	for _, tc := range snakeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if snake := ToSnake(tc.name); snake != tc.snake {
+				t.Fatalf("unexpected snake_case: %v expected: %v", snake, tc.snake)
+			}
+		})
+	}
+
+	// The names that were not converted to snake_case by the converter are
+	// converted with the acronyms in upper case.
+	camelCases := []struct {
+		snake string
+		camel string
+	}{
+		{snake: "http_port", camel: "HTTPPort"},
+		{snake: "url", camel: "URL"},
+		{snake: "ip_address", camel: "IPAddress"},
+		{snake: "base_url", camel: "baseURL"},
+		{snake: "http2_enabled", camel: "HTTP2Enabled"},
+		{snake: "http_2_enabled", camel: "HTTP2Enabled"},
+	}
+	for _, tc := range camelCases {
+		t.Run(tc.snake, func(t *testing.T) {
+			if camel := NewConverter().ToCamel(tc.snake); camel != tc.camel {
+				t.Fatalf("unexpected camelCase: %v expected: %v", camel, tc.camel)
+			}
+		})
+	}
+}
+
+func TestLastConvertedName(t *testing.T) {
+	c := NewConverter()
+	if snake := c.ToSnake("httpPort"); snake != "http_port" {
+		t.Fatalf("unexpected snake_case: %v expected: http_port", snake)
+	}
+	if camel := c.ToCamel("http_port"); camel != "httpPort" {
+		t.Fatalf("unexpected camelCase: %v expected: httpPort", camel)
+	}
+	if snake := c.ToSnake("HTTPPort"); snake != "http_port" {
+		t.Fatalf("unexpected snake_case: %v expected: http_port", snake)
+	}
+	if camel := c.ToCamel("http_port"); camel != "HTTPPort" {
+		t.Fatalf("unexpected camelCase: %v expected: HTTPPort", camel)
+	}
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"serviceAccount": "memcached",
+		"HTTPPort":       8080,
+		"http2Enabled":   true,
+		"ipAddress":      "10.0.0.1",
+		"volumes": []interface{}{
+			map[string]interface{}{"hostPath": "/data", "readOnly": true},
+		},
+		"podSecurityContext": map[string]interface{}{"runAsUser": 1000},
+	}
+	snake := MapToSnake(in)
+	expected := map[string]interface{}{
+		"service_account": "memcached",
+		"http_port":       8080,
+		"http2_enabled":   true,
+		"ip_address":      "10.0.0.1",
+		"volumes": []interface{}{
+			map[string]interface{}{"host_path": "/data", "read_only": true},
+		},
+		"pod_security_context": map[string]interface{}{"run_as_user": 1000},
+	}
+	if !reflect.DeepEqual(snake, expected) {
+		t.Fatalf("unexpected snake_case map: %#v expected: %#v", snake, expected)
+	}
+	if camel := MapToCamel(snake); !reflect.DeepEqual(camel, in) {
+		t.Fatalf("unexpected camelCase map: %#v expected: %#v", camel, in)
+	}
+}
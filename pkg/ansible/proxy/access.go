@@ -0,0 +1,220 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// discoveryPathAcceptRE is the set of paths of the discovery of the API,
+	// accepted from every run.
+	discoveryPathAcceptRE = "^/version/?$,^/apis?/?$,^/api/[^/]+/?$,^/apis/[^/]+/?$,^/apis/[^/]+/[^/]+/?$"
+	// anyHostAcceptRE accepts every host, the proxy only listens locally.
+	anyHostAcceptRE = "^.*$"
+	// allMethods are the methods of the requests for resources.
+	allMethods = "GET,POST,PUT,PATCH,DELETE"
+)
+
+// discoveryPaths are the compiled discoveryPathAcceptRE.
+var discoveryPaths = MakeRegexpArrayOrDie(discoveryPathAcceptRE)
+
+// AccessHandler will reject the requests of the runs that their owner is not
+// allowed to make: requests for resources outside of the namespace of the
+// owner when restrictToOwnerNamespace is set, and requests for the kinds or
+// with the verbs that are not in the access rules of the controller of the
+// owner in cMap. Rejected requests are logged with their owner. Requests
+// whose path is not clean are rejected first, since the filters and the
+// handlers that follow match the path as is while the API server resolves its
+// "." and ".." segments.
+func AccessHandler(h http.Handler, cMap *controllermap.ControllerMap, restMapper meta.RESTMapper, restrictToOwnerNamespace bool) http.Handler {
+	rules := &ruleFilters{restMapper: restMapper, filters: map[schema.GroupVersionKind][]*FilterServer{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		owner, err := ownerFromRequest(req)
+		if err != nil {
+			logrus.Error(err.Error())
+			unauthorized(w)
+			return
+		}
+		if !isCleanPath(req.URL.Path) {
+			logrus.Warnf("rejecting %s %s from the run of %s %s/%s: the path is not clean", req.Method, req.URL.Path, owner.Kind, owner.Namespace, owner.Name)
+			http.Error(w, fmt.Sprintf("%s is not a clean path", req.URL.Path), http.StatusBadRequest)
+			return
+		}
+		host := extractHost(req.Host)
+		if restrictToOwnerNamespace && owner.Namespace != "" && !namespaceFilter(owner.Namespace).accept(req.Method, req.URL.Path, host) {
+			rejectRequest(w, req, owner, "outside of the namespace of the owner")
+			return
+		}
+		if cMap == nil || restMapper == nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		ownerGVK := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+		c, ok := cMap.Get(ownerGVK)
+		if !ok || len(c.AccessRules) == 0 {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if matchesRegexp(req.URL.Path, discoveryPaths) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		for _, f := range rules.get(ownerGVK, c.AccessRules) {
+			if f.accept(req.Method, req.URL.Path, host) {
+				h.ServeHTTP(w, req)
+				return
+			}
+		}
+		rejectRequest(w, req, owner, "not allowed by the allowedResources of "+ownerGVK.String())
+	})
+}
+
+// rejectRequest logs the rejected request with its owner and answers it.
+func rejectRequest(w http.ResponseWriter, req *http.Request, owner kubeconfig.Owner, reason string) {
+	logrus.Warnf("rejecting %s %s from the run of %s %s/%s: %s", req.Method, req.URL.Path, owner.Kind, owner.Namespace, owner.Name, reason)
+	http.Error(w, fmt.Sprintf("%s %s is %s", req.Method, req.URL.Path, reason), http.StatusForbidden)
+}
+
+// isCleanPath returns true if p is absolute and has no empty, "." or ".."
+// segment. A trailing slash is allowed.
+func isCleanPath(p string) bool {
+	cleaned := path.Clean(p)
+	if cleaned != "/" && strings.HasSuffix(p, "/") {
+		cleaned += "/"
+	}
+	return strings.HasPrefix(p, "/") && cleaned == p
+}
+
+// namespaceFilter returns a FilterServer accepting the discovery of the API
+// and the requests for the resources of namespace, including the namespace
+// itself.
+func namespaceFilter(namespace string) *FilterServer {
+	ns := regexp.QuoteMeta(namespace)
+	return &FilterServer{
+		AcceptPaths: MakeRegexpArrayOrDie(discoveryPathAcceptRE +
+			",^/api/[^/]+/namespaces/" + ns + "(/|$)" +
+			",^/apis/[^/]+/[^/]+/namespaces/" + ns + "(/|$)"),
+		AcceptHosts:   MakeRegexpArrayOrDie(anyHostAcceptRE),
+		RejectMethods: MakeRegexpArrayOrDie(DefaultMethodRejectRE),
+	}
+}
+
+// ruleFilters holds the FilterServers of the access rules of each watched
+// GVK, built once the kinds of the rules are known to the REST mapper.
+type ruleFilters struct {
+	mutex      sync.Mutex
+	restMapper meta.RESTMapper
+	filters    map[schema.GroupVersionKind][]*FilterServer
+}
+
+// get returns one FilterServer per access rule of the owner GVK. The rules
+// whose kinds are not known yet, for instance when their CRD is created
+// later, are skipped and built again on the next request.
+func (r *ruleFilters) get(owner schema.GroupVersionKind, rules []runner.AccessRule) []*FilterServer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if filters, ok := r.filters[owner]; ok {
+		return filters
+	}
+	filters := []*FilterServer{}
+	complete := true
+	for _, rule := range rules {
+		f, err := ruleFilter(rule, r.restMapper)
+		if err != nil {
+			logrus.Errorf("unable to apply the allowedResources %v of %v: %v", rule.Kind, owner, err)
+			complete = false
+			continue
+		}
+		filters = append(filters, f)
+	}
+	if complete {
+		r.filters[owner] = filters
+	}
+	return filters
+}
+
+// ruleFilter returns a FilterServer accepting the requests for the resources
+// of the kind of rule, made with the methods of its verbs.
+func ruleFilter(rule runner.AccessRule, restMapper meta.RESTMapper) (*FilterServer, error) {
+	gk := schema.GroupKind{Group: rule.Group, Kind: rule.Kind}
+	versions := []string{}
+	if rule.Version != "" {
+		versions = append(versions, rule.Version)
+	}
+	mappings, err := restMapper.RESTMappings(gk, versions...)
+	if err != nil {
+		return nil, err
+	}
+	// Only the subresources named by the rule are accepted, the exec or
+	// attach of pods must not be allowed by the get of pods.
+	subresources := ""
+	if len(rule.Subresources) > 0 {
+		quoted := []string{}
+		for _, s := range rule.Subresources {
+			quoted = append(quoted, regexp.QuoteMeta(s))
+		}
+		subresources = "|/[^/]+/(" + strings.Join(quoted, "|") + ")"
+	}
+	paths := []string{}
+	for _, m := range mappings {
+		prefix := "^/apis/" + regexp.QuoteMeta(m.Resource.Group) + "/" + regexp.QuoteMeta(m.Resource.Version) + "/"
+		if m.Resource.Group == "" {
+			prefix = "^/api/" + regexp.QuoteMeta(m.Resource.Version) + "/"
+		}
+		resource := regexp.QuoteMeta(m.Resource.Resource) + "(/[^/]+" + subresources + ")?/?$"
+		if m.Scope.Name() == meta.RESTScopeNameNamespace {
+			paths = append(paths, prefix+"namespaces/[^/]+/"+resource, prefix+regexp.QuoteMeta(m.Resource.Resource)+"/?$")
+		} else {
+			paths = append(paths, prefix+resource)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no resource found for %v", gk)
+	}
+
+	allowed := map[string]bool{}
+	for _, m := range rule.Methods() {
+		allowed[m] = true
+	}
+	rejected := []string{}
+	for _, m := range strings.Split(allMethods, ",") {
+		if !allowed[m] {
+			rejected = append(rejected, "^"+m+"$")
+		}
+	}
+	rejectMethods := DefaultMethodRejectRE
+	if len(rejected) > 0 {
+		rejectMethods = strings.Join(rejected, ",")
+	}
+
+	return &FilterServer{
+		AcceptPaths:   MakeRegexpArrayOrDie(strings.Join(paths, ",")),
+		AcceptHosts:   MakeRegexpArrayOrDie(anyHostAcceptRE),
+		RejectMethods: MakeRegexpArrayOrDie(rejectMethods),
+	}, nil
+}
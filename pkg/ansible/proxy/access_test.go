@@ -0,0 +1,236 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAccessHandler(t *testing.T) {
+	ownerGVK := schema.GroupVersionKind{Group: "cache.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}, {Group: "apps", Version: "v1"}})
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	restMapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	cMap := controllermap.NewControllerMap()
+	cMap.Store(ownerGVK, &controllermap.Contents{AccessRules: []runner.AccessRule{
+		{Group: "apps", Kind: "Deployment", Verbs: []string{"*"}, Subresources: []string{"status"}},
+		{Kind: "ConfigMap", Verbs: []string{"get"}},
+		{Kind: "Namespace", Verbs: []string{"get"}},
+		{Kind: "Pod", Verbs: []string{"get"}},
+	}})
+	owner := metav1.OwnerReference{APIVersion: ownerGVK.GroupVersion().String(), Kind: ownerGVK.Kind, Name: "example", UID: "uid"}
+	apiServer := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		namespace string
+		restrict  bool
+		expected  int
+	}{
+		{
+			name:     "discovery",
+			method:   http.MethodGet,
+			path:     "/apis/apps/v1",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "allowed kind and verb",
+			method:   http.MethodDelete,
+			path:     "/apis/apps/v1/namespaces/default/deployments/example",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "allowed kind and verb across namespaces",
+			method:   http.MethodGet,
+			path:     "/api/v1/configmaps",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "allowed kind and verb not allowed",
+			method:   http.MethodPut,
+			path:     "/api/v1/namespaces/default/configmaps/example",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "allowed subresource",
+			method:   http.MethodPut,
+			path:     "/apis/apps/v1/namespaces/default/deployments/example/status",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "subresource not allowed",
+			method:   http.MethodPut,
+			path:     "/apis/apps/v1/namespaces/default/deployments/example/scale",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "get of a pod",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/pods/example",
+			expected: http.StatusOK,
+		},
+		{
+			name:     "exec of a pod allowed to get",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/pods/example/exec",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "attach of a pod allowed to get",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/pods/example/attach",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "log of a pod allowed to get",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/pods/example/log",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "portforward of a pod allowed to get",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/pods/example/portforward",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "status of a namespace allowed to get",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/status",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "kind not allowed",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/secrets/example",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "kind not allowed inside of an allowed namespace",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/secrets",
+			expected: http.StatusForbidden,
+		},
+		{
+			name:      "namespace of the owner",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/configmaps/example",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusOK,
+		},
+		{
+			name:      "another namespace",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/other/configmaps/example",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusForbidden,
+		},
+		{
+			name:      "cluster scoped resource",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/other",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusForbidden,
+		},
+		{
+			name:      "parent segments leaving the namespace of the owner",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/../../namespaces/other/secrets",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusBadRequest,
+		},
+		{
+			name:      "encoded parent segments",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/%2e%2e/%2e%2e/namespaces/other/secrets",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusBadRequest,
+		},
+		{
+			name:     "current segment hiding a kind not allowed",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/default/configmaps/./../secrets/example",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:      "empty segment",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces//configmaps",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusBadRequest,
+		},
+		{
+			name:      "trailing slash",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/configmaps/",
+			namespace: "default",
+			restrict:  true,
+			expected:  http.StatusOK,
+		},
+		{
+			name:      "another namespace without restriction",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/other/configmaps/example",
+			namespace: "default",
+			expected:  http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := kubeconfig.NewTokens()
+			h := AuthenticateHandler(AccessHandler(apiServer, cMap, restMapper, tc.restrict), tokens)
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			setOwner(t, req, tokens, owner, tc.namespace)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.expected {
+				t.Fatalf("unexpected status code: %v expected: %v", rec.Code, tc.expected)
+			}
+		})
+	}
+}
+
+// setOwner authenticates the request with the token of a run of owner, like
+// the kubeconfig of the run does, and returns the token.
+func setOwner(t *testing.T, req *http.Request, tokens *kubeconfig.Tokens, owner metav1.OwnerReference, namespace string) string {
+	token, err := tokens.Add(kubeconfig.Owner{OwnerReference: owner, Namespace: namespace})
+	if err != nil {
+		t.Fatalf("unable to create a token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token
+}
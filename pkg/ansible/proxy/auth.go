@@ -0,0 +1,87 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/sirupsen/logrus"
+)
+
+// ownerKey is the key of the owner of the run making a request in the context
+// of the request.
+type ownerKey struct{}
+
+// dependentRecorderKey is the key of the function recording the resources
+// annotated with the owner of the run making a request, in the context of the
+// request.
+type dependentRecorderKey struct{}
+
+// AuthenticateHandler will reject with 401 the requests that are not made
+// with the bearer token of a run in progress, found in tokens. The owner of
+// the run is added to the context of the accepted requests, for the handlers
+// that follow, and the token is removed so that the proxy can set the
+// authorization of the operator. The resources annotated with the owner are
+// recorded in tokens, see recordDependent.
+func AuthenticateHandler(h http.Handler, tokens *kubeconfig.Tokens) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := bearerToken(req)
+		if token == "" {
+			logrus.Errorf("rejecting %s %s: bearer token not found", req.Method, req.URL.Path)
+			unauthorized(w)
+			return
+		}
+		owner, ok := tokens.Get(token)
+		if !ok {
+			logrus.Errorf("rejecting %s %s: unknown or expired token", req.Method, req.URL.Path)
+			unauthorized(w)
+			return
+		}
+		req.Header.Del("Authorization")
+		ctx := context.WithValue(req.Context(), ownerKey{}, owner)
+		ctx = context.WithValue(ctx, dependentRecorderKey{}, func(d kubeconfig.Dependent) {
+			tokens.AddDependent(token, d)
+		})
+		h.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// recordDependent records that the run making the request annotated a
+// resource of the kind of d with its owner, so that the operator can delete
+// it with the owner.
+func recordDependent(req *http.Request, d kubeconfig.Dependent) {
+	if record, ok := req.Context().Value(dependentRecorderKey{}).(func(kubeconfig.Dependent)); ok {
+		record(d)
+	}
+}
+
+// bearerToken returns the bearer token of the request, empty if it has none.
+func bearerToken(req *http.Request) string {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// unauthorized answers a request that is not authenticated.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer realm=\"Operator Proxy\"")
+	http.Error(w, "", http.StatusUnauthorized)
+}
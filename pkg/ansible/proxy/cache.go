@@ -0,0 +1,147 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CacheResponseHandler will serve the GET requests of the resources whose
+// kinds are watched by the controllers in cMap, as primary or dependent
+// resources, from informerCache, which holds the resources of namespace or of
+// every namespace when namespace is empty. The other requests, the requests
+// asking for fresh data and the objects that are not found in informerCache
+// are passed to h, which sends them to the API server.
+func CacheResponseHandler(h http.Handler, informerCache client.Reader, restMapper meta.RESTMapper, cMap *controllermap.ControllerMap, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !canBeCached(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		info := parseRequestInfo(req.URL.Path)
+		if !info.IsResourceRequest || info.Subresource != "" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gvr := info.GroupVersionResource()
+		gvk, err := restMapper.KindFor(gvr)
+		if err != nil {
+			logrus.Debugf("unable to find the kind of %v, not using the cache: %v", gvr, err)
+			h.ServeHTTP(w, req)
+			return
+		}
+		if !cMap.IsWatched(gvk) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		mapping, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			logrus.Debugf("unable to find the scope of %v, not using the cache: %v", gvk, err)
+			h.ServeHTTP(w, req)
+			return
+		}
+		objNamespace := info.Namespace
+		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+			objNamespace = ""
+		}
+		// The cache only holds the resources of the watched namespace.
+		if namespace != "" && objNamespace != namespace {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		var obj interface{}
+		if info.Name != "" {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(gvk)
+			err = informerCache.Get(context.TODO(), types.NamespacedName{Namespace: objNamespace, Name: info.Name}, u)
+			// The object may have been created by the run and not have
+			// reached the cache yet.
+			if apierrors.IsNotFound(err) {
+				h.ServeHTTP(w, req)
+				return
+			}
+			obj = u
+		} else {
+			selector, parseErr := labels.Parse(req.URL.Query().Get("labelSelector"))
+			if parseErr != nil {
+				// Let the API server report the invalid selector.
+				h.ServeHTTP(w, req)
+				return
+			}
+			l := &unstructured.UnstructuredList{}
+			l.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+			err = informerCache.List(context.TODO(), &client.ListOptions{Namespace: objNamespace, LabelSelector: selector}, l)
+			for i := range l.Items {
+				l.Items[i].SetGroupVersionKind(gvk)
+			}
+			obj = l
+		}
+		if err != nil {
+			logrus.Errorf("unable to read %v from the cache, sending the request to the API server: %v", req.URL.Path, err)
+			h.ServeHTTP(w, req)
+			return
+		}
+		body, err := json.Marshal(obj)
+		if err != nil {
+			logrus.Errorf("unable to serialize %v read from the cache: %v", req.URL.Path, err)
+			h.ServeHTTP(w, req)
+			return
+		}
+		logrus.Debugf("serving %v from the cache", req.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			logrus.Errorf("unable to write the response for %v: %v", req.URL.Path, err)
+		}
+	})
+}
+
+// canBeCached returns false for the requests the cache can not answer like
+// the API server would: watches, paginated lists, field selectors, formats
+// other than JSON and requests asking for fresh data with the Cache-Control
+// header or a resourceVersion.
+func canBeCached(req *http.Request) bool {
+	q := req.URL.Query()
+	if q.Get("watch") == "true" || q.Get("watch") == "1" || q.Get("limit") != "" || q.Get("continue") != "" || q.Get("fieldSelector") != "" {
+		return false
+	}
+	if rv := q.Get("resourceVersion"); rv != "" && rv != "0" {
+		return false
+	}
+	if strings.Contains(req.Header.Get("Cache-Control"), "no-cache") {
+		return false
+	}
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	if strings.Contains(accept, ";as=") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "*/*")
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeCache holds the config maps of the default namespace.
+type fakeCache struct {
+	objects []unstructured.Unstructured
+}
+
+func (c *fakeCache) Get(_ context.Context, key client.ObjectKey, obj runtime.Object) error {
+	for _, o := range c.objects {
+		if o.GetNamespace() == key.Namespace && o.GetName() == key.Name {
+			o.DeepCopyInto(obj.(*unstructured.Unstructured))
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+}
+
+func (c *fakeCache) List(_ context.Context, opts *client.ListOptions, list runtime.Object) error {
+	l := list.(*unstructured.UnstructuredList)
+	for _, o := range c.objects {
+		if o.GetNamespace() == opts.Namespace && opts.LabelSelector.Matches(labels.Set(o.GetLabels())) {
+			l.Items = append(l.Items, *o.DeepCopy())
+		}
+	}
+	return nil
+}
+
+func TestCacheResponseHandler(t *testing.T) {
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	secretGVK := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}})
+	restMapper.Add(configMapGVK, meta.RESTScopeNamespace)
+	restMapper.Add(secretGVK, meta.RESTScopeNamespace)
+	cMap := controllermap.NewControllerMap()
+	cMap.Store(configMapGVK, &controllermap.Contents{})
+
+	newConfigMap := func(name string, l map[string]string) unstructured.Unstructured {
+		u := unstructured.Unstructured{}
+		u.SetGroupVersionKind(configMapGVK)
+		u.SetNamespace("default")
+		u.SetName(name)
+		u.SetLabels(l)
+		return u
+	}
+	informerCache := &fakeCache{objects: []unstructured.Unstructured{
+		newConfigMap("canary", map[string]string{"track": "canary"}),
+		newConfigMap("stable", map[string]string{"track": "stable"}),
+	}}
+	apiServer := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Served-By", "api-server")
+		w.WriteHeader(http.StatusOK)
+	})
+	h := CacheResponseHandler(apiServer, informerCache, restMapper, cMap, "default")
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		header    http.Header
+		fromCache bool
+		names     []string
+	}{
+		{
+			name:      "get from the cache",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/configmaps/canary",
+			fromCache: true,
+			names:     []string{"canary"},
+		},
+		{
+			name:      "list from the cache",
+			method:    http.MethodGet,
+			path:      "/api/v1/namespaces/default/configmaps?labelSelector=track%3Dstable",
+			fromCache: true,
+			names:     []string{"stable"},
+		},
+		{
+			name:   "get not found in the cache",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/default/configmaps/missing",
+		},
+		{
+			name:   "get of a kind that is not watched",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/default/secrets/canary",
+		},
+		{
+			name:   "get from another namespace",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/other/configmaps/canary",
+		},
+		{
+			name:   "get of fresh data",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/default/configmaps/canary",
+			header: http.Header{"Cache-Control": []string{"no-cache"}},
+		},
+		{
+			name:   "watch",
+			method: http.MethodGet,
+			path:   "/api/v1/namespaces/default/configmaps?watch=true",
+		},
+		{
+			name:   "update",
+			method: http.MethodPut,
+			path:   "/api/v1/namespaces/default/configmaps/canary",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %v", rec.Code)
+			}
+			fromCache := rec.Header().Get("X-Served-By") == ""
+			if fromCache != tc.fromCache {
+				t.Fatalf("expected the request to be served from the cache: %v, got: %v", tc.fromCache, fromCache)
+			}
+			if !tc.fromCache {
+				return
+			}
+
+			u := &unstructured.Unstructured{}
+			if err := json.Unmarshal(rec.Body.Bytes(), u); err != nil {
+				t.Fatalf("unable to unmarshal the response: %v", err)
+			}
+			names := []string{u.GetName()}
+			if u.IsList() {
+				l, err := u.ToList()
+				if err != nil {
+					t.Fatalf("unable to read the list: %v", err)
+				}
+				if l.GetKind() != "ConfigMapList" {
+					t.Fatalf("unexpected kind of list: %v", l.GetKind())
+				}
+				names = []string{}
+				for _, item := range l.Items {
+					if item.GroupVersionKind() != configMapGVK {
+						t.Fatalf("unexpected GVK of an item: %v", item.GroupVersionKind())
+					}
+					names = append(names, item.GetName())
+				}
+			}
+			if len(names) != len(tc.names) || names[0] != tc.names[0] {
+				t.Fatalf("expected %v, got %v", tc.names, names)
+			}
+		})
+	}
+}
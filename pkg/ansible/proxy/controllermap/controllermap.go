@@ -0,0 +1,166 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllermap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// watchSyncWarningDelay - how long to wait for the cache of a dependent GVK to
+// sync before warning about it.
+const watchSyncWarningDelay = time.Minute
+
+// ControllerMap - map of the watched GVKs to their controllers. It is shared
+// by the operator, which stores the controllers, and the proxy, which adds
+// watches for the resources created by the runs.
+type ControllerMap struct {
+	mutex    sync.Mutex
+	internal map[schema.GroupVersionKind]*Contents
+}
+
+// Contents - the controller of a watched GVK and the dependent resources it
+// watches.
+type Contents struct {
+	Controller              controller.Controller
+	WatchDependentResources bool
+	// AccessRules - the kinds and verbs the proxy accepts from the runs of
+	// the controller, every one of them when empty.
+	AccessRules []runner.AccessRule
+	// ChangedOwners - records the owners enqueued for their dependent
+	// resources, so that skipUnchanged does not skip them. Optional.
+	ChangedOwners *handler.ChangedOwners
+
+	// dependents - the GVKs of the dependent resources being watched, true
+	// once their cache has synced.
+	dependents map[schema.GroupVersionKind]bool
+}
+
+// NewControllerMap - creates an empty ControllerMap.
+func NewControllerMap() *ControllerMap {
+	return &ControllerMap{
+		internal: map[schema.GroupVersionKind]*Contents{},
+	}
+}
+
+// Store - adds the controller of a watched GVK.
+func (cm *ControllerMap) Store(gvk schema.GroupVersionKind, c *Contents) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if c.dependents == nil {
+		c.dependents = map[schema.GroupVersionKind]bool{}
+	}
+	cm.internal[gvk] = c
+}
+
+// Get - returns the controller of a watched GVK.
+func (cm *ControllerMap) Get(gvk schema.GroupVersionKind) (*Contents, bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	c, ok := cm.internal[gvk]
+	return c, ok
+}
+
+// WatchDependent - makes the controller of the owner GVK reconcile the owner
+// when a resource of the dependent GVK that it owns, through an owner
+// reference or the annotations of the handler package, changes. It does nothing
+// if the owner is not watched, does not watch dependent resources or already
+// watches the dependent GVK.
+// The watch is added in a go routine, since it waits for the cache of the
+// dependent GVK to sync, which never happens when the operator is not allowed
+// to list and watch the dependent GVK.
+func (cm *ControllerMap) WatchDependent(owner, dependent schema.GroupVersionKind) {
+	cm.mutex.Lock()
+	c, ok := cm.internal[owner]
+	if !ok || !c.WatchDependentResources {
+		cm.mutex.Unlock()
+		return
+	}
+	if _, reserved := c.dependents[dependent]; reserved {
+		cm.mutex.Unlock()
+		return
+	}
+	// The dependent GVK is reserved, so that it is watched once, but it is
+	// not watched until its cache has synced.
+	c.dependents[dependent] = false
+	cm.mutex.Unlock()
+
+	go func() {
+		synced := make(chan struct{})
+		go func() {
+			select {
+			case <-synced:
+			case <-time.After(watchSyncWarningDelay):
+				logrus.Warnf("still waiting for the cache of dependent resource %v of %v to sync, check that the operator can list and watch it", dependent, owner)
+			}
+		}()
+		err := watch(c.Controller, owner, dependent, c.ChangedOwners)
+		close(synced)
+
+		cm.mutex.Lock()
+		defer cm.mutex.Unlock()
+		if err != nil {
+			logrus.Errorf("unable to watch dependent resource %v of %v: %v", dependent, owner, err)
+			// The next resource of the dependent GVK tries again.
+			delete(c.dependents, dependent)
+			return
+		}
+		logrus.Infof("Watching dependent resource %v of %v", dependent, owner)
+		c.dependents[dependent] = true
+	}()
+}
+
+// watch - adds the watches of the resources of the dependent GVK owned by
+// resources of the owner GVK to ctr, recording the owners enqueued in changed.
+func watch(ctr controller.Controller, owner, dependent schema.GroupVersionKind, changed *handler.ChangedOwners) error {
+	ownerType := &unstructured.Unstructured{}
+	ownerType.SetGroupVersionKind(owner)
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(dependent)
+	err := ctr.Watch(&source.Kind{Type: u}, handler.RecordChanges(&crthandler.EnqueueRequestForOwner{OwnerType: ownerType}, changed))
+	if err != nil {
+		return err
+	}
+	return ctr.Watch(&source.Kind{Type: u}, handler.RecordChanges(handler.EnqueueRequestForAnnotation(owner.GroupKind()), changed))
+}
+
+// IsWatched - returns true if the resources of gvk are watched by one of the
+// controllers, as the GVK of the controller or as dependent resources, so
+// that they are held by the cache of the manager.
+func (cm *ControllerMap) IsWatched(gvk schema.GroupVersionKind) bool {
+	if cm == nil {
+		return false
+	}
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if _, ok := cm.internal[gvk]; ok {
+		return true
+	}
+	for _, c := range cm.internal {
+		if c.dependents[gvk] {
+			return true
+		}
+	}
+	return false
+}
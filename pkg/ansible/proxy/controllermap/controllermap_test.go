@@ -0,0 +1,86 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllermap
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// blockingController waits for synced to be closed before adding a watch,
+// like a controller waiting for the cache of a kind to sync.
+type blockingController struct {
+	synced  chan struct{}
+	watches chan struct{}
+}
+
+func (c *blockingController) Reconcile(reconcile.Request) (reconcile.Result, error) {
+	return reconcile.Result{}, nil
+}
+
+func (c *blockingController) Watch(source.Source, handler.EventHandler, ...predicate.Predicate) error {
+	<-c.synced
+	c.watches <- struct{}{}
+	return nil
+}
+
+func (c *blockingController) Start(<-chan struct{}) error {
+	return nil
+}
+
+func TestWatchDependent(t *testing.T) {
+	owner := schema.GroupVersionKind{Group: "cache.example.com", Version: "v1alpha1", Kind: "Memcached"}
+	dependent := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	ctr := &blockingController{synced: make(chan struct{}), watches: make(chan struct{}, 4)}
+	cMap := NewControllerMap()
+	cMap.Store(owner, &Contents{Controller: ctr, WatchDependentResources: true})
+
+	returned := make(chan struct{})
+	go func() {
+		cMap.WatchDependent(owner, dependent)
+		cMap.WatchDependent(owner, dependent)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchDependent waited for the cache to sync")
+	}
+	if _, ok := cMap.Get(owner); !ok {
+		t.Fatal("the controller map is locked while the cache syncs")
+	}
+	if cMap.IsWatched(dependent) {
+		t.Fatal("the dependent GVK is watched before its cache has synced")
+	}
+
+	close(ctr.synced)
+	for i := 0; i < 2; i++ {
+		<-ctr.watches
+	}
+	for start := time.Now(); !cMap.IsWatched(dependent); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the dependent GVK is not watched once its cache has synced")
+		}
+	}
+	if len(ctr.watches) != 0 {
+		t.Fatalf("the dependent GVK was watched %v more times", len(ctr.watches))
+	}
+}
@@ -16,20 +16,16 @@ package kubeconfig
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
-	"html/template"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"text/template"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// kubectl, as of 1.10.5, only does basic auth if the username is present in
-// the URL. The python client used by ansible, as of 6.0.0, only does basic
-// auth if the username and password are provided under the "user" key within
-// "users".
+// The token of the run is sent as a bearer token, which both kubectl and the
+// python client used by ansible read from the "user" key within "users".
 const kubeConfigTemplate = `---
 apiVersion: v1
 kind: Config
@@ -48,32 +44,36 @@ preferences: {}
 users:
 - name: admin/proxy-server
   user:
-    username: {{.Username}}
-    password: unused
+    token: {{.Token}}
 `
 
+// Owner is the resource a run is for, found by the proxy from the token of
+// the requests of the run.
+type Owner struct {
+	metav1.OwnerReference
+	// Namespace is the namespace of the resource, empty when the resource
+	// is cluster scoped.
+	Namespace string `json:"namespace,omitempty"`
+}
+
 // values holds the data used to render the template
 type values struct {
-	Username  string
+	Token     string
 	ProxyURL  string
 	Namespace string
 }
 
-// Create renders a kubeconfig template and writes it to disk
-func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (*os.File, error) {
+// Create renders a kubeconfig template authenticating with the token of a run,
+// created with Tokens.Add, and writes it to disk. proxyURL is either the http
+// URL of the proxy or the unix:// URL of its socket.
+func Create(token string, proxyURL string, namespace string) (*os.File, error) {
 	parsedURL, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, err
 	}
-	ownerRefJSON, err := json.Marshal(ownerRef)
-	if err != nil {
-		return nil, err
-	}
-	username := base64.URLEncoding.EncodeToString([]byte(ownerRefJSON))
-	parsedURL.User = url.User(username)
 	v := values{
-		Username:  username,
-		ProxyURL:  parsedURL.String(),
+		Token:     token,
+		ProxyURL:  serverURL(parsedURL),
 		Namespace: namespace,
 	}
 
@@ -99,3 +99,14 @@ func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (
 	}
 	return file, nil
 }
+
+// serverURL returns the server of the kubeconfig for the URL of the proxy. A
+// unix socket is written as an http+unix URL, holding the escaped path of the
+// socket as its host, which is how the clients dialing unix sockets over http
+// expect it.
+func serverURL(proxyURL *url.URL) string {
+	if proxyURL.Scheme != "unix" {
+		return proxyURL.String()
+	}
+	return "http+unix://" + url.PathEscape(proxyURL.Path)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeconfig
+
+import (
+	"os"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestCreate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		proxyURL string
+		server   string
+	}{
+		{
+			name:     "http",
+			proxyURL: "http://127.0.0.1:34567",
+			server:   "http://127.0.0.1:34567",
+		},
+		{
+			name:     "unix socket",
+			proxyURL: "unix:///tmp/ansible-operator/proxy.sock",
+			server:   "http+unix://%2Ftmp%2Fansible-operator%2Fproxy.sock",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := Create("token", tc.proxyURL, "default")
+			if err != nil {
+				t.Fatalf("unable to create the kubeconfig: %v", err)
+			}
+			defer os.Remove(f.Name())
+			config, err := clientcmd.LoadFromFile(f.Name())
+			if err != nil {
+				t.Fatalf("unable to load the kubeconfig: %v", err)
+			}
+			cluster, ok := config.Clusters["proxy-server"]
+			if !ok {
+				t.Fatalf("cluster proxy-server not found in the kubeconfig")
+			}
+			if cluster.Server != tc.server {
+				t.Fatalf("unexpected server: %v expected: %v", cluster.Server, tc.server)
+			}
+			if token := config.AuthInfos["admin/proxy-server"].Token; token != "token" {
+				t.Fatalf("unexpected token: %v", token)
+			}
+			if config.CurrentContext != "default/proxy-server" {
+				t.Fatalf("unexpected current context: %v", config.CurrentContext)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeconfig
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// tokenBytes is the number of random bytes of a token.
+const tokenBytes = 32
+
+// Tokens maps the tokens of the runs in progress to their owners. It is
+// shared by the operator, which adds a token before each run and removes it
+// once the run finishes, and the proxy, which only accepts the requests made
+// with one of the tokens.
+type Tokens struct {
+	mutex  sync.RWMutex
+	owners map[string]Owner
+	// dependents are the resources of each run owned through annotations.
+	dependents map[string][]Dependent
+}
+
+// Dependent is a kind of the resources owned by the owner of a run through
+// annotations, and their namespace, empty when they are cluster scoped.
+type Dependent struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// NewTokens creates an empty Tokens.
+func NewTokens() *Tokens {
+	return &Tokens{owners: map[string]Owner{}, dependents: map[string][]Dependent{}}
+}
+
+// Add creates a random token for a run of owner.
+func (t *Tokens) Add(owner Owner) (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.owners[token] = owner
+	return token, nil
+}
+
+// Remove expires the token of a run.
+func (t *Tokens) Remove(token string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.owners, token)
+	delete(t.dependents, token)
+}
+
+// Get returns the owner of the run the token was created for, false when the
+// token is unknown or expired.
+func (t *Tokens) Get(token string) (Owner, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	owner, ok := t.owners[token]
+	return owner, ok
+}
+
+// AddDependent records that the run of the token annotated a resource with
+// its owner.
+func (t *Tokens) AddDependent(token string, d Dependent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.owners[token]; !ok {
+		return
+	}
+	for _, existing := range t.dependents[token] {
+		if existing == d {
+			return
+		}
+	}
+	t.dependents[token] = append(t.dependents[token], d)
+}
+
+// Dependents returns the kinds of the resources the run of the token
+// annotated with its owner so far.
+func (t *Tokens) Dependents(token string) []Dependent {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]Dependent{}, t.dependents[token]...)
+}
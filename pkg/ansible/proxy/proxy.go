@@ -19,46 +19,57 @@ package proxy
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // InjectOwnerReferenceHandler will handle proxied requests and inject the
-// owner refernece found in the authorization header. The Authorization is
-// then deleted so that the proxy can re-set with the correct authorization.
-func InjectOwnerReferenceHandler(h http.Handler) http.Handler {
+// owner refernece of the run making the request, found by AuthenticateHandler,
+// in the resources created, updated or patched by the run. The resources that
+// an owner reference cannot cover, such as cluster scoped resources or
+// resources in another namespace than the owner, are annotated with the owner
+// instead, see the handler package.
+// The Authorization is then deleted so that the proxy can re-set with the
+// correct authorization.
+// When cMap is set, the controller of the owner is asked to watch the kinds
+// of the resources that are created, updated or patched by the run.
+func InjectOwnerReferenceHandler(h http.Handler, cMap *controllermap.ControllerMap, restMapper meta.RESTMapper) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == http.MethodPost {
+		switch req.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			info := parseRequestInfo(req.URL.Path)
+			pt := patchType(req)
+			// JSON patches and the subresources, such as the status, are
+			// left as is.
+			if !info.IsResourceRequest || info.Subresource != "" || pt == types.JSONPatchType {
+				break
+			}
 			logrus.Info("injecting owner reference")
 			dump, _ := httputil.DumpRequest(req, false)
 			logrus.Debugf(string(dump))
 
-			user, _, ok := req.BasicAuth()
+			owner, ok := getRequestOwner(w, req)
 			if !ok {
-				logrus.Error("basic auth header not found")
-				w.Header().Set("WWW-Authenticate", "Basic realm=\"Operator Proxy\"")
-				http.Error(w, "", http.StatusUnauthorized)
-				return
-			}
-			authString, err := base64.StdEncoding.DecodeString(user)
-			if err != nil {
-				m := "could not base64 decode username"
-				logrus.Errorf("%s", err.Error())
-				http.Error(w, m, http.StatusBadRequest)
 				return
 			}
-			owner := metav1.OwnerReference{}
-			json.Unmarshal(authString, &owner)
-
-			logrus.Debugf("%#+v", owner)
 
 			body, err := ioutil.ReadAll(req.Body)
 			if err != nil {
@@ -67,15 +78,29 @@ func InjectOwnerReferenceHandler(h http.Handler) http.Handler {
 				http.Error(w, m, http.StatusInternalServerError)
 				return
 			}
-			data := &unstructured.Unstructured{}
-			err = json.Unmarshal(body, data)
+			// Patches are partial objects without a kind, which
+			// unstructured.Unstructured refuses to deserialize.
+			object := map[string]interface{}{}
+			err = json.Unmarshal(body, &object)
 			if err != nil {
 				m := "could not deserialize request body"
 				logrus.Errorf("%s", err.Error())
 				http.Error(w, m, http.StatusBadRequest)
 				return
 			}
-			data.SetOwnerReferences(append(data.GetOwnerReferences(), owner))
+			data := &unstructured.Unstructured{Object: object}
+			ownerGVK := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+			annotated := !canOwn(owner, info)
+			// A JSON merge patch replaces the owner references of the
+			// resource, unless it sets them itself.
+			if _, ok, _ := unstructured.NestedFieldNoCopy(data.Object, "metadata", "ownerReferences"); pt == types.MergePatchType && !ok {
+				annotated = true
+			}
+			if annotated {
+				handler.SetOwnerAnnotations(data, ownerGVK.GroupKind(), types.NamespacedName{Namespace: owner.Namespace, Name: owner.Name})
+			} else {
+				data.SetOwnerReferences(appendOwnerReference(data.GetOwnerReferences(), owner.OwnerReference))
+			}
 			newBody, err := json.Marshal(data.Object)
 			if err != nil {
 				m := "could not serialize body"
@@ -86,6 +111,22 @@ func InjectOwnerReferenceHandler(h http.Handler) http.Handler {
 			logrus.Debugf(string(newBody))
 			req.Body = ioutil.NopCloser(bytes.NewBuffer(newBody))
 			req.ContentLength = int64(len(newBody))
+
+			gvk := data.GroupVersionKind()
+			if gvk.Kind == "" && restMapper != nil {
+				gvk, err = restMapper.KindFor(info.GroupVersionResource())
+				if err != nil {
+					logrus.Debugf("unable to find the kind of %v: %v", info.GroupVersionResource(), err)
+				}
+			}
+			if annotated {
+				if gvk.Kind == "" {
+					logrus.Warnf("unable to find the kind of %v, it will not be deleted with %s %s/%s", req.URL.Path, owner.Kind, owner.Namespace, owner.Name)
+				} else {
+					recordDependent(req, kubeconfig.Dependent{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Namespace: resourceNamespace(info)})
+				}
+			}
+			watchDependent(cMap, owner.OwnerReference, gvk)
 		}
 		// Removing the authorization so that the proxy can set the correct authorization.
 		req.Header.Del("Authorization")
@@ -93,6 +134,86 @@ func InjectOwnerReferenceHandler(h http.Handler) http.Handler {
 	})
 }
 
+// patchType returns the type of the patch of a PATCH request, empty for the
+// other requests.
+func patchType(req *http.Request) types.PatchType {
+	if req.Method != http.MethodPatch {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return types.PatchType(mediaType)
+}
+
+// canOwn returns true if an owner reference to owner can be set on the
+// resource of the request. Cluster scoped owners can own any resource, while
+// namespaced owners only own resources of their namespace.
+func canOwn(owner kubeconfig.Owner, info requestInfo) bool {
+	if owner.Namespace == "" {
+		return true
+	}
+	return resourceNamespace(info) == owner.Namespace
+}
+
+// resourceNamespace returns the namespace of the resource of the request,
+// empty when it is cluster scoped.
+func resourceNamespace(info requestInfo) string {
+	// Namespaces are cluster scoped even though their path holds their name
+	// as namespace.
+	if info.APIGroup == "" && info.Resource == "namespaces" {
+		return ""
+	}
+	return info.Namespace
+}
+
+// appendOwnerReference appends owner to refs, unless it is already one of
+// them, as when a resource read from the API is updated.
+func appendOwnerReference(refs []metav1.OwnerReference, owner metav1.OwnerReference) []metav1.OwnerReference {
+	for _, ref := range refs {
+		if ref.UID == owner.UID {
+			return refs
+		}
+	}
+	return append(refs, owner)
+}
+
+// getRequestOwner returns the owner of the run making the request. When it is
+// not known, 401 is written to w and false is returned.
+func getRequestOwner(w http.ResponseWriter, req *http.Request) (kubeconfig.Owner, bool) {
+	owner, err := ownerFromRequest(req)
+	if err != nil {
+		logrus.Error(err.Error())
+		unauthorized(w)
+		return owner, false
+	}
+	logrus.Debugf("%#+v", owner)
+	return owner, true
+}
+
+var errNoOwner = errors.New("owner of the request not found")
+
+// ownerFromRequest returns the owner of the run making the request, set by
+// AuthenticateHandler.
+func ownerFromRequest(req *http.Request) (kubeconfig.Owner, error) {
+	owner, ok := req.Context().Value(ownerKey{}).(kubeconfig.Owner)
+	if !ok {
+		return owner, errNoOwner
+	}
+	return owner, nil
+}
+
+// watchDependent asks the controller of the owner to watch the dependent
+// kind, without waiting for the watch. Failures are logged, since they should
+// not fail the request.
+func watchDependent(cMap *controllermap.ControllerMap, owner metav1.OwnerReference, dependent schema.GroupVersionKind) {
+	if cMap == nil || dependent.Kind == "" {
+		return
+	}
+	cMap.WatchDependent(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind), dependent)
+}
+
 // HandlerChain will be used for users to pass defined handlers to the proxy.
 // The hander chain will be run after InjectingOwnerReference if it is added
 // and before the proxy handler.
@@ -101,35 +222,89 @@ type HandlerChain func(http.Handler) http.Handler
 // Options will be used by the user to specify the desired details
 // for the proxy.
 type Options struct {
-	Address          string
-	Port             int
+	// Address and Port are where the proxy listens, a free port is picked
+	// when Port is 0.
+	Address string
+	Port    int
+	// SocketPath is the path of a unix socket the proxy listens on instead
+	// of Address and Port, only accessible to the user of the operator.
+	// Optional.
+	SocketPath       string
 	Handler          HandlerChain
 	NoOwnerInjection bool
 	KubeConfig       *rest.Config
+	// ControllerMap holds the controllers of the watched kinds, used to watch
+	// the dependent resources created by the runs. Optional.
+	ControllerMap *controllermap.ControllerMap
+	// RESTMapper maps the paths of requests to kinds. Optional.
+	RESTMapper meta.RESTMapper
+	// Cache serves the GET requests of the watched kinds and of their
+	// dependent resources, usually the cache of the manager. Requires
+	// ControllerMap and RESTMapper. Optional.
+	Cache client.Reader
+	// WatchNamespace is the namespace held by Cache, empty when it holds
+	// every namespace.
+	WatchNamespace string
+	// RestrictToOwnerNamespace rejects the requests of the runs for the
+	// resources outside of the namespace of their owner.
+	RestrictToOwnerNamespace bool
+	// Tokens holds the tokens of the runs in progress, the requests made
+	// without one of them are rejected. Required.
+	Tokens *kubeconfig.Tokens
 }
 
 // Run will start a proxy server in a go routine that returns on the error
 // channel if something is not correct on startup. Run will not return until
-// the network socket is listening.
-func Run(done chan error, o Options) error {
+// the network socket is listening, and returns the URL of the proxy to write
+// in the kubeconfig of the runs.
+func Run(done chan error, o Options) (string, error) {
+	if o.Tokens == nil {
+		return "", errors.New("the tokens of the runs must be set to authenticate them")
+	}
 	server, err := newServer("/", o.KubeConfig)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if o.Cache != nil && o.ControllerMap != nil && o.RESTMapper != nil {
+		server.Handler = CacheResponseHandler(server.Handler, o.Cache, o.RESTMapper, o.ControllerMap, o.WatchNamespace)
 	}
 	if o.Handler != nil {
 		server.Handler = o.Handler(server.Handler)
 	}
 
 	if !o.NoOwnerInjection {
-		server.Handler = InjectOwnerReferenceHandler(server.Handler)
+		server.Handler = InjectOwnerReferenceHandler(server.Handler, o.ControllerMap, o.RESTMapper)
 	}
-	l, err := server.Listen(o.Address, o.Port)
+	// The runs are authenticated first, then their access is checked.
+	server.Handler = AccessHandler(server.Handler, o.ControllerMap, o.RESTMapper, o.RestrictToOwnerNamespace)
+	server.Handler = AuthenticateHandler(server.Handler, o.Tokens)
+	l, proxyURL, err := listen(server, o)
 	if err != nil {
-		return err
+		return "", err
 	}
 	go func() {
 		logrus.Infof("Starting to serve on %s\n", l.Addr().String())
 		done <- server.ServeOnListener(l)
 	}()
-	return nil
+	return proxyURL, nil
+}
+
+// listen listens on the unix socket or on the address and port of o, and
+// returns the listener with the URL of the proxy.
+func listen(s *server, o Options) (net.Listener, string, error) {
+	if o.SocketPath != "" {
+		l, err := s.ListenUnix(o.SocketPath)
+		if err != nil {
+			return nil, "", err
+		}
+		u := url.URL{Scheme: "unix", Path: o.SocketPath}
+		return l, u.String(), nil
+	}
+	l, err := s.Listen(o.Address, o.Port)
+	if err != nil {
+		return nil, "", err
+	}
+	// The address of the listener holds the port picked when Port is 0.
+	u := url.URL{Scheme: "http", Host: l.Addr().String()}
+	return l, u.String(), nil
 }
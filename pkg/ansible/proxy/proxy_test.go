@@ -0,0 +1,287 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/handler"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+func TestInjectOwnerReferenceHandler(t *testing.T) {
+	owner := metav1.OwnerReference{APIVersion: "cache.example.com/v1alpha1", Kind: "Memcached", Name: "example", UID: "uid"}
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "Secret", Name: "other", UID: "other"}
+	restMapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}})
+	restMapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	testCases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        map[string]interface{}
+		ownerRefs   []metav1.OwnerReference
+		annotated   bool
+		dependent   *kubeconfig.Dependent
+	}{
+		{
+			name:      "create in the namespace of the owner",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces/default/configmaps",
+			body:      map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "example"}},
+			ownerRefs: []metav1.OwnerReference{owner},
+		},
+		{
+			name:      "update keeping the owner references",
+			method:    http.MethodPut,
+			path:      "/api/v1/namespaces/default/configmaps/example",
+			body:      map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "example", "ownerReferences": []interface{}{ownerRefMap(other), ownerRefMap(owner)}}},
+			ownerRefs: []metav1.OwnerReference{other, owner},
+		},
+		{
+			name:        "strategic merge patch",
+			method:      http.MethodPatch,
+			path:        "/api/v1/namespaces/default/configmaps/example",
+			contentType: string(types.StrategicMergePatchType),
+			body:        map[string]interface{}{"data": map[string]interface{}{"key": "value"}},
+			ownerRefs:   []metav1.OwnerReference{owner},
+		},
+		{
+			name:        "merge patch",
+			method:      http.MethodPatch,
+			path:        "/api/v1/namespaces/default/configmaps/example",
+			contentType: string(types.MergePatchType),
+			body:        map[string]interface{}{"data": map[string]interface{}{"key": "value"}},
+			annotated:   true,
+			dependent:   &kubeconfig.Dependent{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default"},
+		},
+		{
+			name:        "merge patch setting the owner references",
+			method:      http.MethodPatch,
+			path:        "/api/v1/namespaces/default/configmaps/example",
+			contentType: string(types.MergePatchType) + "; charset=utf-8",
+			body:        map[string]interface{}{"metadata": map[string]interface{}{"ownerReferences": []interface{}{ownerRefMap(other)}}},
+			ownerRefs:   []metav1.OwnerReference{other, owner},
+		},
+		{
+			name:        "json patch",
+			method:      http.MethodPatch,
+			path:        "/api/v1/namespaces/default/configmaps/example",
+			contentType: string(types.JSONPatchType),
+		},
+		{
+			name:      "create in another namespace",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces/other/configmaps",
+			body:      map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "example"}},
+			annotated: true,
+			dependent: &kubeconfig.Dependent{APIVersion: "v1", Kind: "ConfigMap", Namespace: "other"},
+		},
+		{
+			name:      "create a cluster scoped resource",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces",
+			body:      map[string]interface{}{"apiVersion": "v1", "kind": "Namespace", "metadata": map[string]interface{}{"name": "example"}},
+			annotated: true,
+			dependent: &kubeconfig.Dependent{APIVersion: "v1", Kind: "Namespace"},
+		},
+		{
+			name:   "update a subresource",
+			method: http.MethodPut,
+			path:   "/api/v1/namespaces/default/configmaps/example/status",
+			body:   map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "example"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received *unstructured.Unstructured
+			apiServer := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				object := map[string]interface{}{}
+				if tc.body == nil {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				if err := json.NewDecoder(req.Body).Decode(&object); err != nil {
+					t.Fatalf("unable to decode the body: %v", err)
+				}
+				received = &unstructured.Unstructured{Object: object}
+				w.WriteHeader(http.StatusOK)
+			})
+			body := []byte("[]")
+			if tc.body != nil {
+				var err error
+				if body, err = json.Marshal(tc.body); err != nil {
+					t.Fatalf("unable to serialize the body: %v", err)
+				}
+			}
+			tokens := kubeconfig.NewTokens()
+			h := AuthenticateHandler(InjectOwnerReferenceHandler(apiServer, nil, restMapper), tokens)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", tc.contentType)
+			token := setOwner(t, req, tokens, owner, "default")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %v", rec.Code)
+			}
+			if tc.body == nil {
+				return
+			}
+
+			refs := received.GetOwnerReferences()
+			if len(refs) != len(tc.ownerRefs) {
+				t.Fatalf("expected the owner references %v, got: %v", tc.ownerRefs, refs)
+			}
+			for i := range refs {
+				if refs[i].UID != tc.ownerRefs[i].UID {
+					t.Fatalf("expected the owner references %v, got: %v", tc.ownerRefs, refs)
+				}
+			}
+			o, ok := handler.OwnerFromAnnotations(received, schema.GroupKind{Group: "cache.example.com", Kind: owner.Kind})
+			if ok != tc.annotated {
+				t.Fatalf("expected the owner annotations: %v, got: %v", tc.annotated, received.GetAnnotations())
+			}
+			if ok && o != (types.NamespacedName{Namespace: "default", Name: owner.Name}) {
+				t.Fatalf("unexpected owner in the annotations: %v", o)
+			}
+			dependents := tokens.Dependents(token)
+			if tc.dependent == nil && len(dependents) != 0 || tc.dependent != nil && (len(dependents) != 1 || dependents[0] != *tc.dependent) {
+				t.Fatalf("expected the dependent %v to be recorded, got: %v", tc.dependent, dependents)
+			}
+		})
+	}
+}
+
+func TestCanOwn(t *testing.T) {
+	ref := metav1.OwnerReference{APIVersion: "cache.example.com/v1alpha1", Kind: "Memcached", Name: "example", UID: "uid"}
+	testCases := []struct {
+		name      string
+		namespace string
+		path      string
+		expected  bool
+	}{
+		{name: "same namespace", namespace: "default", path: "/api/v1/namespaces/default/configmaps", expected: true},
+		{name: "another namespace", namespace: "default", path: "/apis/apps/v1/namespaces/other/deployments/example", expected: false},
+		{name: "cluster scoped resource", namespace: "default", path: "/apis/rbac.authorization.k8s.io/v1/clusterroles", expected: false},
+		{name: "namespace of the owner", namespace: "default", path: "/api/v1/namespaces/default", expected: false},
+		{name: "cluster scoped owner", path: "/api/v1/namespaces/other/configmaps", expected: true},
+		{name: "cluster scoped owner of a cluster scoped resource", path: "/api/v1/namespaces", expected: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			owner := kubeconfig.Owner{OwnerReference: ref, Namespace: tc.namespace}
+			if can := canOwn(owner, parseRequestInfo(tc.path)); can != tc.expected {
+				t.Fatalf("expected canOwn to return %v, got: %v", tc.expected, can)
+			}
+		})
+	}
+}
+
+func ownerRefMap(ref metav1.OwnerReference) map[string]interface{} {
+	return map[string]interface{}{"apiVersion": ref.APIVersion, "kind": ref.Kind, "name": ref.Name, "uid": string(ref.UID)}
+}
+
+func TestRunURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxy")
+	if err != nil {
+		t.Fatalf("unable to create a directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "proxy.sock")
+
+	testCases := []struct {
+		name    string
+		options Options
+		scheme  string
+	}{
+		{
+			name:    "dynamic port",
+			options: Options{Address: "localhost"},
+			scheme:  "http",
+		},
+		{
+			name:    "unix socket",
+			options: Options{SocketPath: socketPath},
+			scheme:  "unix",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.options.KubeConfig = &rest.Config{Host: "http://localhost"}
+			tc.options.Tokens = kubeconfig.NewTokens()
+			proxyURL, err := Run(make(chan error, 1), tc.options)
+			if err != nil {
+				t.Fatalf("unable to run the proxy: %v", err)
+			}
+			u, err := url.Parse(proxyURL)
+			if err != nil {
+				t.Fatalf("unable to parse %q: %v", proxyURL, err)
+			}
+			if u.Scheme != tc.scheme {
+				t.Fatalf("expected the scheme %v, got: %v", tc.scheme, proxyURL)
+			}
+			if tc.scheme == "unix" {
+				if u.Path != socketPath {
+					t.Fatalf("expected the socket %v, got: %v", socketPath, proxyURL)
+				}
+				fi, err := os.Stat(socketPath)
+				if err != nil {
+					t.Fatalf("unable to stat the socket: %v", err)
+				}
+				if fi.Mode().Perm()&0077 != 0 {
+					t.Fatalf("the socket is accessible to other users: %v", fi.Mode())
+				}
+				// The proxy is served on the socket, the request without
+				// the token of a run is rejected.
+				c := &http.Client{Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					},
+				}}
+				resp, err := c.Get("http://proxy/api/v1/namespaces/default/pods")
+				if err != nil {
+					t.Fatalf("unable to send a request on the socket: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusUnauthorized {
+					t.Fatalf("unexpected status code: %v expected: %v", resp.StatusCode, http.StatusUnauthorized)
+				}
+				return
+			}
+			if strings.HasSuffix(u.Host, ":0") || u.Port() == "" {
+				t.Fatalf("expected the port picked by the proxy, got: %v", proxyURL)
+			}
+		})
+	}
+}
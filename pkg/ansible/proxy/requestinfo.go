@@ -0,0 +1,86 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// requestInfo holds the parts of a kubernetes API request path the proxy
+// cares about.
+type requestInfo struct {
+	IsResourceRequest bool
+	APIGroup          string
+	APIVersion        string
+	Namespace         string
+	Resource          string
+	Name              string
+	Subresource       string
+}
+
+// namespaceSubresources are the subresources of namespaces, which are
+// otherwise ambiguous with resources inside of a namespace.
+var namespaceSubresources = map[string]bool{"status": true, "finalize": true}
+
+// parseRequestInfo parses paths like /api/v1/namespaces/default/pods/name or
+// /apis/apps/v1/deployments. Paths that do not address a resource, such as
+// discovery paths, return a requestInfo with IsResourceRequest false.
+func parseRequestInfo(path string) requestInfo {
+	info := requestInfo{}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		info.APIVersion = parts[1]
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		info.APIGroup = parts[1]
+		info.APIVersion = parts[2]
+		parts = parts[3:]
+	default:
+		return info
+	}
+
+	if len(parts) > 1 && parts[0] == "namespaces" {
+		info.Namespace = parts[1]
+		// /namespaces/<name> and its subresources address the namespace itself
+		if len(parts) > 2 && !namespaceSubresources[parts[2]] {
+			parts = parts[2:]
+		}
+	}
+	if len(parts) == 0 {
+		return info
+	}
+
+	info.IsResourceRequest = true
+	info.Resource = parts[0]
+	if len(parts) > 1 {
+		info.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		info.Subresource = parts[2]
+	}
+	return info
+}
+
+// GroupVersionResource returns the resource addressed by the request.
+func (i requestInfo) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    i.APIGroup,
+		Version:  i.APIVersion,
+		Resource: i.Resource,
+	}
+}
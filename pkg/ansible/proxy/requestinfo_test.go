@@ -0,0 +1,68 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"testing"
+)
+
+func TestParseRequestInfo(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected requestInfo
+	}{
+		{
+			path:     "/api",
+			expected: requestInfo{},
+		},
+		{
+			path:     "/apis/apps/v1",
+			expected: requestInfo{APIGroup: "apps", APIVersion: "v1"},
+		},
+		{
+			path:     "/api/v1/namespaces",
+			expected: requestInfo{IsResourceRequest: true, APIVersion: "v1", Resource: "namespaces"},
+		},
+		{
+			path:     "/api/v1/namespaces/default",
+			expected: requestInfo{IsResourceRequest: true, APIVersion: "v1", Namespace: "default", Resource: "namespaces", Name: "default"},
+		},
+		{
+			path:     "/api/v1/namespaces/default/status",
+			expected: requestInfo{IsResourceRequest: true, APIVersion: "v1", Namespace: "default", Resource: "namespaces", Name: "default", Subresource: "status"},
+		},
+		{
+			path:     "/api/v1/namespaces/default/configmaps",
+			expected: requestInfo{IsResourceRequest: true, APIVersion: "v1", Namespace: "default", Resource: "configmaps"},
+		},
+		{
+			path:     "/apis/apps/v1/namespaces/default/deployments/example/scale",
+			expected: requestInfo{IsResourceRequest: true, APIGroup: "apps", APIVersion: "v1", Namespace: "default", Resource: "deployments", Name: "example", Subresource: "scale"},
+		},
+		{
+			path:     "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin",
+			expected: requestInfo{IsResourceRequest: true, APIGroup: "rbac.authorization.k8s.io", APIVersion: "v1", Resource: "clusterroles", Name: "admin"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			info := parseRequestInfo(tc.path)
+			if info != tc.expected {
+				t.Fatalf("unexpected request info for %v\nexpected: %#v\nactual: %#v", tc.path, tc.expected, info)
+			}
+		})
+	}
+}
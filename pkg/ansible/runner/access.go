@@ -0,0 +1,89 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// verbMethods - the HTTP methods of the requests of each verb. The proxy only
+// sees the methods of the requests, so allowing one of get, list and watch
+// allows the three of them.
+var verbMethods = map[string][]string{
+	"get":              {http.MethodGet},
+	"list":             {http.MethodGet},
+	"watch":            {http.MethodGet},
+	"create":           {http.MethodPost},
+	"update":           {http.MethodPut},
+	"patch":            {http.MethodPatch},
+	"delete":           {http.MethodDelete},
+	"deletecollection": {http.MethodDelete},
+	"*":                {http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+}
+
+// AccessRule - a kind whose resources the runs may access through the proxy
+// with the given verbs. Every version of the kind is allowed when Version is
+// not set.
+type AccessRule struct {
+	Group   string   `yaml:"group"`
+	Version string   `yaml:"version"`
+	Kind    string   `yaml:"kind"`
+	Verbs   []string `yaml:"verbs"`
+	// Subresources - the subresources of the kind the runs may also access,
+	// such as status or log. The others, such as the exec of pods, are
+	// rejected.
+	Subresources []string `yaml:"subresources"`
+}
+
+// validate - returns an error when the rule is not well formed.
+func (a AccessRule) validate() error {
+	if a.Kind == "" {
+		return fmt.Errorf("kind must be set")
+	}
+	if len(a.Verbs) == 0 {
+		return fmt.Errorf("verbs must be set for %v", a.Kind)
+	}
+	for _, v := range a.Verbs {
+		if _, ok := verbMethods[v]; !ok {
+			return fmt.Errorf("unknown verb %q for %v", v, a.Kind)
+		}
+	}
+	for _, s := range a.Subresources {
+		if s == "" || strings.Contains(s, "/") {
+			return fmt.Errorf("invalid subresource %q for %v", s, a.Kind)
+		}
+	}
+	return nil
+}
+
+// Methods - returns the sorted HTTP methods of the requests allowed by the
+// verbs of the rule.
+func (a AccessRule) Methods() []string {
+	set := map[string]bool{}
+	for _, v := range a.Verbs {
+		for _, m := range verbMethods[v] {
+			set[m] = true
+		}
+	}
+	methods := []string{}
+	for m := range set {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
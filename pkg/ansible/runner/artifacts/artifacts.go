@@ -0,0 +1,249 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPort - port the artifacts are served on by default. A free port
+	// is picked when Options.Port is 0.
+	DefaultPort = 8889
+
+	// StdoutFile - the output of ansible-runner for a run.
+	StdoutFile = "stdout"
+	// JobEventsDir - the directory holding one file per event of a run.
+	JobEventsDir = "job_events"
+	// ExtraVarsFile - the extravars a run was started with.
+	ExtraVarsFile = "extravars"
+)
+
+// Run - a run of ansible-runner that has artifacts.
+type Run struct {
+	Ident   string    `json:"ident"`
+	Created time.Time `json:"created"`
+}
+
+// Dir - returns the directory ansible-runner writes the artifacts of the run
+// ident to, within the input directory at inputDirPath.
+func Dir(inputDirPath, ident string) string {
+	return filepath.Join(inputDirPath, "artifacts", ident)
+}
+
+// List - returns the runs that have artifacts in the input directory at
+// inputDirPath, most recent first.
+func List(inputDirPath string) ([]Run, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(inputDirPath, "artifacts"))
+	if os.IsNotExist(err) {
+		return []Run{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	runs := []Run{}
+	for _, fi := range infos {
+		if fi.IsDir() {
+			runs = append(runs, Run{Ident: fi.Name(), Created: fi.ModTime()})
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Created.After(runs[j].Created)
+	})
+	return runs, nil
+}
+
+// Prune - removes the artifacts of all but the keep most recent runs in the
+// input directory at inputDirPath.
+func Prune(inputDirPath string, keep int) error {
+	runs, err := List(inputDirPath)
+	if err != nil {
+		return err
+	}
+	if len(runs) <= keep {
+		return nil
+	}
+	for _, run := range runs[keep:] {
+		err := os.RemoveAll(Dir(inputDirPath, run.Ident))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite - replaces the content of each file in the artifacts directory of a
+// run, runDir, with the result of rewrite.
+func Rewrite(runDir string, rewrite func([]byte) []byte) error {
+	return filepath.Walk(runDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, rewrite(b), fi.Mode())
+	})
+}
+
+// Options will be used by the user to specify the desired details for the
+// artifacts server.
+type Options struct {
+	Address string
+	Port    int
+	// Dir - the directory holding the input directories of the runs, laid
+	// out as <group>/<version>/<kind>/<namespace>/<name>.
+	Dir string
+}
+
+// Handler - serves the artifacts of the runs found in dir. The paths of
+// cluster-scoped resources have no <namespace>.
+//
+//	GET /<group>/<version>/<kind>/<namespace>/<name>
+//	    the runs of the resource, most recent first
+//	GET /<group>/<version>/<kind>/<namespace>/<name>/<ident>/stdout
+//	    the output of ansible-runner
+//	GET /<group>/<version>/<kind>/<namespace>/<name>/<ident>/job_events
+//	    the events of the run, in order
+//	GET /<group>/<version>/<kind>/<namespace>/<name>/<ident>/extravars
+//	    the extravars the run was started with
+func Handler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		for _, p := range parts {
+			if p == "" || p == "." || p == ".." {
+				http.NotFound(w, req)
+				return
+			}
+		}
+		resourceParts := 5
+		if len(parts)%2 == 0 {
+			resourceParts = 4
+		}
+		switch len(parts) - resourceParts {
+		case 0:
+			runs, err := List(filepath.Join(dir, filepath.Join(parts...)))
+			if err != nil {
+				logrus.Errorf("unable to list artifacts: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, runs)
+		case 2:
+			runDir := Dir(filepath.Join(dir, filepath.Join(parts[:resourceParts]...)), parts[resourceParts])
+			switch parts[resourceParts+1] {
+			case StdoutFile:
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				http.ServeFile(w, req, filepath.Join(runDir, StdoutFile))
+			case ExtraVarsFile:
+				w.Header().Set("Content-Type", "application/json")
+				http.ServeFile(w, req, filepath.Join(runDir, ExtraVarsFile))
+			case JobEventsDir:
+				events, err := readJobEvents(filepath.Join(runDir, JobEventsDir))
+				if os.IsNotExist(err) {
+					http.NotFound(w, req)
+					return
+				}
+				if err != nil {
+					logrus.Errorf("unable to read job events: %v", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				writeJSON(w, events)
+			default:
+				http.NotFound(w, req)
+			}
+		default:
+			http.NotFound(w, req)
+		}
+	})
+}
+
+// readJobEvents - reads the events ansible-runner wrote to dir, one file named
+// <counter>-<uuid>.json per event, ordered by counter.
+func readJobEvents(dir string) ([]json.RawMessage, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	counter := func(name string) int {
+		c, err := strconv.Atoi(strings.SplitN(name, "-", 2)[0])
+		if err != nil {
+			return 0
+		}
+		return c
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return counter(infos[i].Name()) < counter(infos[j].Name())
+	})
+	events := []json.RawMessage{}
+	for _, fi := range infos {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, json.RawMessage(b))
+	}
+	return events, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Serve will start the artifacts server in a go routine that returns on the
+// error channel if something is not correct on startup. Serve will not return
+// until the network socket is listening.
+func Serve(done chan error, o Options) error {
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", o.Address, o.Port))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: Handler(o.Dir)}
+	go func() {
+		logrus.Infof("Serving runner artifacts on %s", l.Addr().String())
+		done <- server.Serve(l)
+	}()
+	return nil
+}
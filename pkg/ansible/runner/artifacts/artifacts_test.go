@@ -0,0 +1,173 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRun(t *testing.T, inputDirPath, ident string, created time.Time) {
+	dir := Dir(inputDirPath, ident)
+	if err := os.MkdirAll(filepath.Join(dir, JobEventsDir), os.ModePerm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]string{
+		StdoutFile:                               "PLAY RECAP",
+		ExtraVarsFile:                            `{"size":1}`,
+		filepath.Join(JobEventsDir, "2-b.json"):  `{"counter":2}`,
+		filepath.Join(JobEventsDir, "10-c.json"): `{"counter":10}`,
+		filepath.Join(JobEventsDir, "1-a.json"):  `{"counter":1}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := os.Chtimes(dir, created, created); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	inputDirPath, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(inputDirPath)
+
+	now := time.Now()
+	writeRun(t, inputDirPath, "1", now.Add(-3*time.Minute))
+	writeRun(t, inputDirPath, "2", now.Add(-2*time.Minute))
+	writeRun(t, inputDirPath, "3", now.Add(-1*time.Minute))
+
+	if err := Prune(inputDirPath, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runs, err := List(inputDirPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Ident != "3" || runs[1].Ident != "2" {
+		t.Fatalf("unexpected runs after prune: %#v", runs)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	resourcePath := "app.example.com/v1alpha1/Database/default/example"
+	writeRun(t, filepath.Join(dir, resourcePath), "1", time.Now())
+	clusterResourcePath := "app.example.com/v1alpha1/Cluster/example"
+	writeRun(t, filepath.Join(dir, clusterResourcePath), "1", time.Now())
+
+	server := httptest.NewServer(Handler(dir))
+	defer server.Close()
+
+	testCases := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "list runs",
+			path:         "/" + resourcePath,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "stdout",
+			path:         "/" + resourcePath + "/1/stdout",
+			expectedCode: http.StatusOK,
+			expectedBody: "PLAY RECAP",
+		},
+		{
+			name:         "extravars",
+			path:         "/" + resourcePath + "/1/extravars",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"size":1}`,
+		},
+		{
+			name:         "job events in order",
+			path:         "/" + resourcePath + "/1/job_events",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"counter":1},{"counter":2},{"counter":10}]`,
+		},
+		{
+			name:         "list runs of a cluster-scoped resource",
+			path:         "/" + clusterResourcePath,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "stdout of a cluster-scoped resource",
+			path:         "/" + clusterResourcePath + "/1/stdout",
+			expectedCode: http.StatusOK,
+			expectedBody: "PLAY RECAP",
+		},
+		{
+			name:         "unknown file",
+			path:         "/" + resourcePath + "/1/env",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown run",
+			path:         "/" + resourcePath + "/2/job_events",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "parent directory",
+			path:         "/app.example.com/v1alpha1/Database/default/../../../../..%2fetc",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.expectedCode {
+				t.Fatalf("unexpected status code: %v expected: %v", resp.StatusCode, tc.expectedCode)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectedBody != "" && string(b) != tc.expectedBody {
+				t.Fatalf("unexpected body: %s expected: %s", b, tc.expectedBody)
+			}
+			if strings.HasPrefix(tc.name, "list runs") {
+				runs := []Run{}
+				if err := json.Unmarshal(b, &runs); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(runs) != 1 || runs[0].Ident != "1" {
+					t.Fatalf("unexpected runs: %#v", runs)
+				}
+			}
+		})
+	}
+}
@@ -21,10 +21,12 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,6 +43,16 @@ type EventReceiver struct {
 	// received. For example, "/events/"
 	URLPath string
 
+	// DroppedEvents, when set, counts the events that were received but could
+	// not be sent on the Events channel. It must be set before events are
+	// received.
+	DroppedEvents prometheus.Counter
+
+	// Filter, when set, selects the events sent on the Events channel. The
+	// other events are acknowledged and discarded. It must be set before
+	// events are received.
+	Filter func(JobEvent) bool
+
 	// server is the http.Server instance that serves the event API. It must be
 	// closed.
 	server io.Closer
@@ -99,6 +111,11 @@ func (e *EventReceiver) Close() {
 	e.logger.Debug("event API stopped")
 	e.server.Close()
 	close(e.Events)
+	// The listener normally removes the socket when it is closed, but make
+	// sure it does not outlive the receiver.
+	if err := os.Remove(e.SocketPath); err != nil && !os.IsNotExist(err) {
+		e.logger.Errorf("unable to remove socket: %s", err.Error())
+	}
 }
 
 func (e *EventReceiver) handleEvents(w http.ResponseWriter, r *http.Request) {
@@ -153,7 +170,7 @@ func (e *EventReceiver) handleEvents(w http.ResponseWriter, r *http.Request) {
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
 	if e.stopped {
-		e.mutex.RUnlock()
+		e.countDropped()
 		w.WriteHeader(http.StatusGone)
 		e.logger.WithFields(logrus.Fields{
 			"code": "410",
@@ -166,6 +183,8 @@ func (e *EventReceiver) handleEvents(w http.ResponseWriter, r *http.Request) {
 	// https://ansible-runner.readthedocs.io/en/latest/external_interface.html#event-structure
 	if event.UUID == "" {
 		e.logger.Info("dropping event that is not a JobEvent")
+	} else if e.Filter != nil && !e.Filter(event) {
+		e.logger.Debugf("filtering out %s event", event.Event)
 	} else {
 		// timeout if the channel blocks for too long
 		timeout := time.NewTimer(10 * time.Second)
@@ -175,6 +194,7 @@ func (e *EventReceiver) handleEvents(w http.ResponseWriter, r *http.Request) {
 			e.logger.WithFields(logrus.Fields{
 				"code": "500",
 			}).Warn("timed out writing event to channel")
+			e.countDropped()
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -182,3 +202,9 @@ func (e *EventReceiver) handleEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (e *EventReceiver) countDropped() {
+	if e.DroppedEvents != nil {
+		e.DroppedEvents.Inc()
+	}
+}
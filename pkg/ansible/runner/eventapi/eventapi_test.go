@@ -0,0 +1,80 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDroppedEvents(t *testing.T) {
+	errChan := make(chan error, 1)
+	receiver, err := New("eventapi-test", errChan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+	receiver.DroppedEvents = dropped
+	receiver.Close()
+
+	req := httptest.NewRequest(http.MethodPost, receiver.URLPath, strings.NewReader(`{"uuid":"1","event":"runner_on_ok"}`))
+	req.Header.Set("content-type", "application/json")
+	w := httptest.NewRecorder()
+	receiver.handleEvents(w, req)
+	if w.Code != http.StatusGone {
+		t.Fatalf("unexpected status code: %v expected: %v", w.Code, http.StatusGone)
+	}
+
+	m := &dto.Metric{}
+	if err := dropped.Write(m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.GetCounter().GetValue() != 1 {
+		t.Fatalf("expected 1 dropped event, got %v", m.GetCounter().GetValue())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	errChan := make(chan error, 1)
+	receiver, err := New("eventapi-filter-test", errChan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer receiver.Close()
+	receiver.Filter = func(e JobEvent) bool {
+		return e.Event != "verbose"
+	}
+
+	for _, body := range []string{`{"uuid":"1","event":"verbose"}`, `{"uuid":"2","event":"runner_on_ok"}`} {
+		req := httptest.NewRequest(http.MethodPost, receiver.URLPath, strings.NewReader(body))
+		req.Header.Set("content-type", "application/json")
+		w := httptest.NewRecorder()
+		receiver.handleEvents(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("unexpected status code: %v expected: %v", w.Code, http.StatusNoContent)
+		}
+	}
+	if len(receiver.Events) != 1 {
+		t.Fatalf("expected 1 event, got %v", len(receiver.Events))
+	}
+	if e := <-receiver.Events; e.Event != "runner_on_ok" {
+		t.Fatalf("unexpected event: %v", e.Event)
+	}
+}
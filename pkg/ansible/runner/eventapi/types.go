@@ -41,15 +41,16 @@ func (e EventTime) MarshalJSON() ([]byte, error) {
 
 // JobEvent - event of an ansible run.
 type JobEvent struct {
-	UUID      string                 `json:"uuid"`
-	Counter   int                    `json:"counter"`
-	StdOut    string                 `json:"stdout"`
-	StartLine int                    `json:"start_line"`
-	EndLine   int                    `json:"EndLine"`
-	Event     string                 `json:"event"`
-	EventData map[string]interface{} `json:"event_data"`
-	PID       int                    `json:"pid"`
-	Created   EventTime              `json:"created"`
+	UUID        string                 `json:"uuid"`
+	Counter     int                    `json:"counter"`
+	StdOut      string                 `json:"stdout"`
+	StartLine   int                    `json:"start_line"`
+	EndLine     int                    `json:"EndLine"`
+	Event       string                 `json:"event"`
+	EventData   map[string]interface{} `json:"event_data"`
+	PID         int                    `json:"pid"`
+	Created     EventTime              `json:"created"`
+	RunnerIdent string                 `json:"runner_ident"`
 }
 
 // StatusJobEvent - event of an ansible run.
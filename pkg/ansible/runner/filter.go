@@ -0,0 +1,155 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+)
+
+// requiredEventTypes - events passed on whatever eventTypes is set to, the
+// operator sets the status of the CR from them.
+var requiredEventTypes = map[string]bool{
+	events.EventPlaybookOnStats: true,
+	events.EventRunnerOnFailed:  true,
+}
+
+// Filter - selects names, such as the event types or the fields of the CR
+// passed to Ansible. When Include is set, only the names it lists are
+// selected. The names listed in Exclude are never selected.
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// validateFields - returns an error when one of the names is not a dotted
+// path of a field.
+func (f Filter) validateFields() error {
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		for _, field := range strings.Split(p, ".") {
+			if field == "" {
+				return fmt.Errorf("invalid field path %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+// allows - whether the filter selects name.
+func (f Filter) allows(name string) bool {
+	if len(f.Include) > 0 && !contains(f.Include, name) {
+		return false
+	}
+	return !contains(f.Exclude, name)
+}
+
+// AllowsEvent - whether the filter selects the type of e. The events in
+// requiredEventTypes and the set_fact tasks, which publish the custom status
+// of the CR, are always selected.
+func (f Filter) AllowsEvent(e eventapi.JobEvent) bool {
+	return requiredEventTypes[e.Event] || isSetFact(e) || f.allows(e.Event)
+}
+
+// isSetFact - whether e is the result of a set_fact task.
+func isSetFact(e eventapi.JobEvent) bool {
+	return e.Event == events.EventRunnerOnOk && e.EventData["task_action"] == events.TaskActionSetFact
+}
+
+// object - returns the fields of obj selected by the filter, the names being
+// dotted paths of fields. obj is not modified, the maps along the selected
+// paths are copied.
+func (f Filter) object(obj map[string]interface{}) map[string]interface{} {
+	if len(f.Include) > 0 {
+		included := map[string]interface{}{}
+		for _, p := range f.Include {
+			path := strings.Split(p, ".")
+			if v, ok := nestedField(obj, path); ok {
+				included = withField(included, path, v)
+			}
+		}
+		obj = included
+	}
+	for _, p := range f.Exclude {
+		obj = withoutField(obj, strings.Split(p, "."))
+	}
+	return obj
+}
+
+func nestedField(obj map[string]interface{}, path []string) (interface{}, bool) {
+	v, ok := obj[path[0]]
+	if !ok || len(path) == 1 {
+		return v, ok
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return nestedField(m, path[1:])
+}
+
+// withField - returns a copy of obj with the field at path set to v.
+func withField(obj map[string]interface{}, path []string, v interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(obj)+1)
+	for k, val := range obj {
+		c[k] = val
+	}
+	if len(path) == 1 {
+		c[path[0]] = v
+		return c
+	}
+	m, ok := obj[path[0]].(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+	}
+	c[path[0]] = withField(m, path[1:], v)
+	return c
+}
+
+// withoutField - returns obj without the field at path, obj itself when it
+// does not have the field.
+func withoutField(obj map[string]interface{}, path []string) map[string]interface{} {
+	v, ok := obj[path[0]]
+	if !ok {
+		return obj
+	}
+	var m map[string]interface{}
+	if len(path) > 1 {
+		if m, ok = v.(map[string]interface{}); !ok {
+			return obj
+		}
+	}
+	c := make(map[string]interface{}, len(obj))
+	for k, val := range obj {
+		c[k] = val
+	}
+	if len(path) == 1 {
+		delete(c, path[0])
+		return c
+	}
+	c[path[0]] = withoutField(m, path[1:])
+	return c
+}
+
+func contains(l []string, s string) bool {
+	for _, elem := range l {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
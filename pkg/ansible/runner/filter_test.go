@@ -0,0 +1,107 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+)
+
+func TestFilterObject(t *testing.T) {
+	newObject := func() map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        "example",
+				"annotations": map[string]interface{}{"large": "blob"},
+			},
+			"spec":   map[string]interface{}{"size": int64(3)},
+			"status": map[string]interface{}{"ready": true},
+		}
+	}
+	testCases := []struct {
+		name     string
+		filter   Filter
+		expected map[string]interface{}
+	}{
+		{
+			name:     "no filter",
+			expected: newObject(),
+		},
+		{
+			name:   "include",
+			filter: Filter{Include: []string{"metadata.name", "spec", "missing.field"}},
+			expected: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "example"},
+				"spec":     map[string]interface{}{"size": int64(3)},
+			},
+		},
+		{
+			name:   "exclude",
+			filter: Filter{Exclude: []string{"metadata.annotations", "status", "spec.size.value"}},
+			expected: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "example"},
+				"spec":     map[string]interface{}{"size": int64(3)},
+			},
+		},
+		{
+			name:   "include and exclude",
+			filter: Filter{Include: []string{"metadata", "metadata.name"}, Exclude: []string{"metadata.annotations"}},
+			expected: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "example"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := newObject()
+			filtered := tc.filter.object(obj)
+			if !reflect.DeepEqual(filtered, tc.expected) {
+				t.Fatalf("unexpected object: %#v expected: %#v", filtered, tc.expected)
+			}
+			if !reflect.DeepEqual(obj, newObject()) {
+				t.Fatalf("the object was modified: %#v", obj)
+			}
+		})
+	}
+}
+
+func TestFilterEvents(t *testing.T) {
+	f := Filter{Exclude: []string{"verbose", "runner_on_start", "playbook_on_stats"}}
+	if f.AllowsEvent(eventapi.JobEvent{Event: "verbose"}) {
+		t.Fatalf("excluded event type was allowed")
+	}
+	if !f.AllowsEvent(eventapi.JobEvent{Event: "runner_on_ok"}) {
+		t.Fatalf("event type that is not excluded was not allowed")
+	}
+	if !f.AllowsEvent(eventapi.JobEvent{Event: "playbook_on_stats"}) {
+		t.Fatalf("required event type was not allowed")
+	}
+	f = Filter{Include: []string{"runner_on_ok"}}
+	if f.AllowsEvent(eventapi.JobEvent{Event: "runner_on_start"}) {
+		t.Fatalf("event type that is not included was allowed")
+	}
+	if !f.AllowsEvent(eventapi.JobEvent{Event: "runner_on_failed"}) {
+		t.Fatalf("required event type was not allowed")
+	}
+	f = Filter{Exclude: []string{"runner_on_ok"}}
+	if f.AllowsEvent(eventapi.JobEvent{Event: "runner_on_ok", EventData: map[string]interface{}{"task_action": "debug"}}) {
+		t.Fatalf("excluded event type was allowed")
+	}
+	if !f.AllowsEvent(eventapi.JobEvent{Event: "runner_on_ok", EventData: map[string]interface{}{"task_action": "set_fact"}}) {
+		t.Fatalf("set_fact task was not allowed")
+	}
+}
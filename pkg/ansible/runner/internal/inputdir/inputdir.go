@@ -24,13 +24,19 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// PlaybookFile - the playbook of the run, relative to the project directory.
+const PlaybookFile = "playbook.yaml"
+
 // InputDir represents an input directory for ansible-runner.
 type InputDir struct {
 	Path         string
 	PlaybookPath string
-	Parameters   map[string]interface{}
-	EnvVars      map[string]string
-	Settings     map[string]string
+	// Playbook - the content of the playbook, used when PlaybookPath is not
+	// set.
+	Playbook   []byte
+	Parameters map[string]interface{}
+	EnvVars    map[string]string
+	Settings   map[string]string
 }
 
 // makeDirs creates the required directory structure.
@@ -46,6 +52,18 @@ func (i *InputDir) makeDirs() error {
 	return nil
 }
 
+// Clean removes the inputs written by Write, leaving the artifacts of the runs
+// in place.
+func (i *InputDir) Clean() error {
+	for _, path := range []string{"env", "project", "inventory"} {
+		err := os.RemoveAll(filepath.Join(i.Path, path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // addFile adds a file to the given relative path within the input directory.
 func (i *InputDir) addFile(path string, content []byte) error {
 	fullPath := filepath.Join(i.Path, path)
@@ -101,6 +119,12 @@ func (i *InputDir) Write() error {
 		return err
 	}
 
+	if i.PlaybookPath == "" && i.Playbook != nil {
+		err = i.addFile(filepath.Join("project", PlaybookFile), i.Playbook)
+		if err != nil {
+			return err
+		}
+	}
 	if i.PlaybookPath != "" {
 		f, err := os.Open(i.PlaybookPath)
 		if err != nil {
@@ -114,7 +138,7 @@ func (i *InputDir) Write() error {
 			return err
 		}
 
-		err = i.addFile("project/playbook.yaml", playbookBytes)
+		err = i.addFile(filepath.Join("project", PlaybookFile), playbookBytes)
 		if err != nil {
 			return err
 		}
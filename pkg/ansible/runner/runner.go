@@ -15,6 +15,9 @@
 package runner
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -23,25 +26,84 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/paramconv"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/artifacts"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/internal/inputdir"
 	"github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// InputDirRoot - the directory holding the input directories of the runs,
+	// laid out as <group>/<version>/<kind>/<namespace>/<name>. The artifacts
+	// of a run are written to artifacts/<ident> in its input directory.
+	InputDirRoot = "/tmp/ansible-operator/runner"
+
+	// LastAppliedSpecField - the status field holding the spec of the last
+	// successful run.
+	LastAppliedSpecField = "lastAppliedSpec"
+
+	// DefaultMaxArtifacts - the number of runs per resource whose artifacts
+	// are kept, unless maxRunnerArtifacts is set in watches.yaml.
+	DefaultMaxArtifacts = 20
+
+	// DefaultFinalizerBackoff and DefaultFinalizerMaxBackoff - delays
+	// between the retries of a failed finalizer run, unless they are set in
+	// the finalizer in watches.yaml.
+	DefaultFinalizerBackoff    = 5 * time.Second
+	DefaultFinalizerMaxBackoff = 5 * time.Minute
+
+	// KeyConversionSnake, KeyConversionNone and KeyConversionBoth - values of
+	// specKeyConversion in watches.yaml. The spec is passed to Ansible with
+	// its keys in snake_case, as they are in the spec, or both. camel is
+	// accepted for none, since the keys of a spec are in camelCase.
+	KeyConversionSnake = "snake"
+	KeyConversionNone  = "none"
+	KeyConversionCamel = "camel"
+	KeyConversionBoth  = "both"
+
+	// AnsibleRolesPathEnvVar and AnsibleCollectionsPathsEnvVar - set for the
+	// runs, so that the roles and collections installed with ansible-galaxy,
+	// such as the dependencies of the roles, are found. The values set for
+	// the operator are kept.
+	AnsibleRolesPathEnvVar        = "ANSIBLE_ROLES_PATH"
+	AnsibleCollectionsPathsEnvVar = "ANSIBLE_COLLECTIONS_PATHS"
+)
+
+// collectionRoleName - matches the fully qualified name of a role of a
+// collection, <namespace>.<collection>.<role>.
+var collectionRoleName = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_]+\.[a-z0-9_]+$`)
+
 // Runner - a runnable that should take the parameters and name and namespace
 // and run the correct code.
 type Runner interface {
-	Run(*unstructured.Unstructured, string) (chan eventapi.JobEvent, error)
+	Run(context.Context, *unstructured.Unstructured, string, client.Client) (chan eventapi.JobEvent, error)
 	GetFinalizer() (string, bool)
+	GetFinalizerTimeout() (time.Duration, bool)
+	GetFinalizerRetry() FinalizerRetry
 	GetReconcilePeriod() (time.Duration, bool)
+	GetTimeout() (time.Duration, bool)
+	GetSkipUnchanged() bool
+	GetMaxWorkers() (int, bool)
+	GetWatchDependentResources() bool
+	GetKubernetesEvents() (bool, bool)
+	GetKubernetesDebugEvents() bool
+	GetSelector() labels.Selector
+	GetAccessRules() []AccessRule
+	GetParametersHash(context.Context, *unstructured.Unstructured, client.Client) (string, error)
 }
 
 // watch holds data used to create a mapping of GVK to ansible playbook or role.
@@ -54,6 +116,43 @@ type watch struct {
 	Role            string     `yaml:"role"`
 	ReconcilePeriod string     `yaml:"reconcilePeriod"`
 	Finalizer       *Finalizer `yaml:"finalizer"`
+	SkipUnchanged   bool       `yaml:"skipUnchanged"`
+	MaxWorkers      int        `yaml:"maxWorkers"`
+	Timeout         string     `yaml:"timeout"`
+	// Roles - the roles run in order, as absolute paths or fully qualified
+	// names of roles of collections.
+	Roles []string `yaml:"roles"`
+
+	MaxRunnerArtifacts    int   `yaml:"maxRunnerArtifacts"`
+	KubernetesEvents      *bool `yaml:"kubernetesEvents"`
+	KubernetesDebugEvents bool  `yaml:"kubernetesDebugEvents"`
+	// StorageVersion - marks the version reconciled when several versions
+	// of the kind are watched, the other versions are only reconciled when
+	// they set their own playbook or roles.
+	StorageVersion bool `yaml:"storageVersion"`
+	// SpecKeyConversion - how the keys of the spec are converted, defaults
+	// to snake.
+	SpecKeyConversion string `yaml:"specKeyConversion"`
+	// Acronyms - words kept in upper case when converting keys back to
+	// camelCase, in addition to paramconv.DefaultAcronyms.
+	Acronyms []string `yaml:"acronyms"`
+	// VarsFrom and EnvFrom - Secrets and ConfigMaps passed to Ansible as
+	// extravars and environment variables.
+	VarsFrom []ValuesFrom `yaml:"varsFrom"`
+	EnvFrom  []ValuesFrom `yaml:"envFrom"`
+	// ObjectFields - the fields of the CR passed as _<group>_<kind>, as
+	// dotted paths.
+	ObjectFields Filter `yaml:"objectFields"`
+	// EventTypes - the types of the events of the runs the operator handles.
+	EventTypes Filter `yaml:"eventTypes"`
+	// Selector - selects the CRs reconciled, every CR of the kind when it
+	// is not set.
+	Selector Selector `yaml:"selector"`
+	// AllowedResources - the kinds and verbs the runs may access through
+	// the proxy, every one of them when it is not set.
+	AllowedResources []AccessRule `yaml:"allowedResources"`
+
+	WatchDependentResources bool `yaml:"watchDependentResources"`
 }
 
 // Finalizer - Expose finalizer to be used by a user.
@@ -62,6 +161,38 @@ type Finalizer struct {
 	Playbook string                 `yaml:"playbook"`
 	Role     string                 `yaml:"role"`
 	Vars     map[string]interface{} `yaml:"vars"`
+	// Timeout - maximum duration of a finalizer run, the timeout of the
+	// watch is used when it is not set.
+	Timeout string `yaml:"timeout"`
+	// Backoff - delay before retrying a failed finalizer run, doubled after
+	// each failed run up to MaxBackoff.
+	Backoff    string `yaml:"backoff"`
+	MaxBackoff string `yaml:"maxBackoff"`
+	// MaxAttempts - number of failed runs after which the finalizer is
+	// removed without running again, 0 to retry until it succeeds.
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+// FinalizerRetry - how failed finalizer runs are retried.
+type FinalizerRetry struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts - number of failed runs after which the finalizer is
+	// removed, 0 when it is retried until it succeeds.
+	MaxAttempts int
+}
+
+// Delay - returns how long to wait before running the finalizer again after
+// attempts failed runs.
+func (f FinalizerRetry) Delay(attempts int) time.Duration {
+	delay := f.Backoff
+	for i := 1; i < attempts && delay < f.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > f.MaxBackoff {
+		delay = f.MaxBackoff
+	}
+	return delay
 }
 
 // NewFromWatches reads the operator's config file at the provided path.
@@ -78,6 +209,24 @@ func NewFromWatches(path string) (map[schema.GroupVersionKind]Runner, error) {
 		return nil, err
 	}
 
+	// The API server serves every version of an object, so the versions other
+	// than the storage version of kinds that have several versions watched
+	// are only reconciled when they set their own playbook or roles.
+	storageVersions := map[schema.GroupKind][]string{}
+	versions := map[schema.GroupKind][]string{}
+	for _, w := range watches {
+		gk := schema.GroupKind{Group: w.Group, Kind: w.Kind}
+		versions[gk] = append(versions[gk], w.Version)
+		if w.StorageVersion {
+			storageVersions[gk] = append(storageVersions[gk], w.Version)
+		}
+	}
+	for gk, vs := range versions {
+		if len(vs) > 1 && len(storageVersions[gk]) != 1 {
+			return nil, fmt.Errorf("exactly one of the versions %v of %v must set storageVersion", vs, gk)
+		}
+	}
+
 	m := map[schema.GroupVersionKind]Runner{}
 	for _, w := range watches {
 		s := schema.GroupVersionKind{
@@ -85,6 +234,10 @@ func NewFromWatches(path string) (map[schema.GroupVersionKind]Runner, error) {
 			Version: w.Version,
 			Kind:    w.Kind,
 		}
+		if len(versions[s.GroupKind()]) > 1 && !w.StorageVersion && w.Playbook == "" && w.Role == "" && len(w.Roles) == 0 {
+			logrus.Infof("Not reconciling %v, %v is the storage version of %v", s.Version, storageVersions[s.GroupKind()][0], s.GroupKind())
+			continue
+		}
 		var reconcilePeriod time.Duration
 		if w.ReconcilePeriod != "" {
 			d, err := time.ParseDuration(w.ReconcilePeriod)
@@ -94,32 +247,117 @@ func NewFromWatches(path string) (map[schema.GroupVersionKind]Runner, error) {
 			reconcilePeriod = d
 		}
 
+		var timeout time.Duration
+		if w.Timeout != "" {
+			d, err := time.ParseDuration(w.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse timeout: %v - %v", w.Timeout, err)
+			}
+			if d < 0 {
+				return nil, fmt.Errorf("timeout must not be negative for %v", s)
+			}
+			timeout = d
+		}
+
+		maxArtifacts := DefaultMaxArtifacts
+		if w.MaxRunnerArtifacts < 0 {
+			return nil, fmt.Errorf("maxRunnerArtifacts must not be negative for %v", s)
+		}
+		if w.MaxRunnerArtifacts > 0 {
+			maxArtifacts = w.MaxRunnerArtifacts
+		}
+
+		if w.MaxWorkers < 0 {
+			return nil, fmt.Errorf("maxWorkers must not be negative for %v", s)
+		}
+
+		keyConversion := KeyConversionSnake
+		switch w.SpecKeyConversion {
+		case "", KeyConversionSnake:
+		case KeyConversionNone, KeyConversionCamel:
+			keyConversion = KeyConversionNone
+		case KeyConversionBoth:
+			keyConversion = KeyConversionBoth
+		default:
+			return nil, fmt.Errorf("specKeyConversion must be one of %v, %v, %v or %v for %v: %v",
+				KeyConversionSnake, KeyConversionCamel, KeyConversionNone, KeyConversionBoth, s, w.SpecKeyConversion)
+		}
+
+		for _, v := range append(append([]ValuesFrom{}, w.VarsFrom...), w.EnvFrom...) {
+			if err := v.validate(); err != nil {
+				return nil, fmt.Errorf("invalid varsFrom or envFrom for %v: %v", s, err)
+			}
+		}
+
+		if err := w.ObjectFields.validateFields(); err != nil {
+			return nil, fmt.Errorf("invalid objectFields for %v: %v", s, err)
+		}
+
+		selector, err := w.Selector.labelSelector()
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector for %v: %v", s, err)
+		}
+
+		for _, a := range w.AllowedResources {
+			if err := a.validate(); err != nil {
+				return nil, fmt.Errorf("invalid allowedResources for %v: %v", s, err)
+			}
+		}
+
 		// Check if schema is a duplicate
 		if _, ok := m[s]; ok {
 			return nil, fmt.Errorf("duplicate GVK: %v", s.String())
 		}
+		var r *runner
 		switch {
 		case w.Playbook != "":
-			r, err := NewForPlaybook(w.Playbook, s, w.Finalizer, reconcilePeriod)
-			if err != nil {
-				return nil, err
-			}
-			m[s] = r
+			r, err = newForPlaybook(w.Playbook, s, w.Finalizer, reconcilePeriod)
+		case w.Role != "" && len(w.Roles) != 0:
+			return nil, fmt.Errorf("only one of role and roles can be defined for %v", s)
+		case w.Role != "" && !collectionRoleName.MatchString(w.Role):
+			r, err = newForRole(w.Role, s, w.Finalizer, reconcilePeriod)
 		case w.Role != "":
-			r, err := NewForRole(w.Role, s, w.Finalizer, reconcilePeriod)
-			if err != nil {
-				return nil, err
-			}
-			m[s] = r
+			r, err = newForRoles([]string{w.Role}, s, w.Finalizer, reconcilePeriod)
+		case len(w.Roles) != 0:
+			r, err = newForRoles(w.Roles, s, w.Finalizer, reconcilePeriod)
 		default:
-			return nil, fmt.Errorf("either playbook or role must be defined for %v", s)
+			return nil, fmt.Errorf("either playbook, role or roles must be defined for %v", s)
+		}
+		if err != nil {
+			return nil, err
 		}
+		r.skipUnchanged = w.SkipUnchanged
+		r.maxWorkers = w.MaxWorkers
+		r.timeout = timeout
+		r.maxArtifacts = maxArtifacts
+		r.watchDependentResources = w.WatchDependentResources
+		r.kubernetesEvents = w.KubernetesEvents
+		r.kubernetesDebugEvents = w.KubernetesDebugEvents
+		r.keyConversion = keyConversion
+		r.converter = paramconv.NewConverter(w.Acronyms...)
+		r.varsFrom = w.VarsFrom
+		r.envFrom = w.EnvFrom
+		r.objectFields = w.ObjectFields
+		r.eventTypes = w.EventTypes
+		r.selector = selector
+		r.accessRules = w.AllowedResources
+		r.configDigest = r.digestConfig()
+		m[s] = r
 	}
 	return m, nil
 }
 
 // NewForPlaybook returns a new Runner based on the path to an ansible playbook.
 func NewForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (Runner, error) {
+	r, err := newForPlaybook(path, gvk, finalizer, reconcilePeriod)
+	if err != nil {
+		return nil, err
+	}
+	r.configDigest = r.digestConfig()
+	return r, nil
+}
+
+func newForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (*runner, error) {
 	if !filepath.IsAbs(path) {
 		return nil, fmt.Errorf("playbook path must be absolute for %v", gvk)
 	}
@@ -133,6 +371,9 @@ func NewForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finaliz
 			return exec.Command("ansible-runner", "-vv", "-p", path, "-i", ident, "run", inputDirPath)
 		},
 		reconcilePeriod: reconcilePeriod,
+		maxArtifacts:    DefaultMaxArtifacts,
+		keyConversion:   KeyConversionSnake,
+		converter:       paramconv.NewConverter(),
 	}
 	err := r.addFinalizer(finalizer)
 	if err != nil {
@@ -143,6 +384,15 @@ func NewForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finaliz
 
 // NewForRole returns a new Runner based on the path to an ansible role.
 func NewForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (Runner, error) {
+	r, err := newForRole(path, gvk, finalizer, reconcilePeriod)
+	if err != nil {
+		return nil, err
+	}
+	r.configDigest = r.digestConfig()
+	return r, nil
+}
+
+func newForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (*runner, error) {
 	if !filepath.IsAbs(path) {
 		return nil, fmt.Errorf("role path must be absolute for %v", gvk)
 	}
@@ -158,6 +408,9 @@ func NewForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer,
 			return exec.Command("ansible-runner", "-vv", "--role", roleName, "--roles-path", rolePath, "--hosts", "localhost", "-i", ident, "run", inputDirPath)
 		},
 		reconcilePeriod: reconcilePeriod,
+		maxArtifacts:    DefaultMaxArtifacts,
+		keyConversion:   KeyConversionSnake,
+		converter:       paramconv.NewConverter(),
 	}
 	err := r.addFinalizer(finalizer)
 	if err != nil {
@@ -166,6 +419,65 @@ func NewForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer,
 	return r, nil
 }
 
+// NewForRoles returns a new Runner that runs several ansible roles in order,
+// each given by its absolute path or by the fully qualified name of a role of
+// a collection.
+func NewForRoles(roles []string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (Runner, error) {
+	r, err := newForRoles(roles, gvk, finalizer, reconcilePeriod)
+	if err != nil {
+		return nil, err
+	}
+	r.configDigest = r.digestConfig()
+	return r, nil
+}
+
+func newForRoles(roles []string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod time.Duration) (*runner, error) {
+	rolesPath := []string{}
+	playbookRoles := []string{}
+	for _, role := range roles {
+		if collectionRoleName.MatchString(role) {
+			playbookRoles = append(playbookRoles, role)
+			continue
+		}
+		if !filepath.IsAbs(role) {
+			return nil, fmt.Errorf("role must be an absolute path or the name of a role of a collection for %v: %v", gvk, role)
+		}
+		if _, err := os.Stat(role); err != nil {
+			return nil, fmt.Errorf("role path: %v was not found for %v", role, gvk)
+		}
+		role = strings.TrimRight(role, "/")
+		playbookRoles = append(playbookRoles, role)
+		rolesPath = append(rolesPath, filepath.Dir(role))
+	}
+	// ansible-runner runs a single role, so run the roles from a playbook.
+	playbook, err := yaml.Marshal([]map[string]interface{}{{
+		"hosts":        "localhost",
+		"gather_facts": false,
+		"roles":        playbookRoles,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{
+		Path: strings.Join(roles, ","),
+		GVK:  gvk,
+		cmdFunc: func(ident, inputDirPath string) *exec.Cmd {
+			return exec.Command("ansible-runner", "-vv", "-p", inputdir.PlaybookFile, "-i", ident, "run", inputDirPath)
+		},
+		reconcilePeriod: reconcilePeriod,
+		maxArtifacts:    DefaultMaxArtifacts,
+		keyConversion:   KeyConversionSnake,
+		converter:       paramconv.NewConverter(),
+		playbook:        playbook,
+		rolesPath:       rolesPath,
+	}
+	err = r.addFinalizer(finalizer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // runner - implements the Runner interface for a GVK that's being watched.
 type runner struct {
 	Path             string                  // path on disk to a playbook or role depending on what cmdFunc expects
@@ -174,12 +486,70 @@ type runner struct {
 	cmdFunc          func(ident, inputDirPath string) *exec.Cmd // returns a Cmd that runs ansible-runner
 	finalizerCmdFunc func(ident, inputDirPath string) *exec.Cmd
 	reconcilePeriod  time.Duration
+	skipUnchanged    bool
+	maxWorkers       int
+	timeout          time.Duration
+	maxArtifacts     int
+	keyConversion    string
+	converter        *paramconv.Converter
+	varsFrom         []ValuesFrom
+	envFrom          []ValuesFrom
+	objectFields     Filter
+	eventTypes       Filter
+	selector         labels.Selector
+	accessRules      []AccessRule
+	finalizerTimeout time.Duration
+	finalizerRetry   FinalizerRetry
+	// playbook - the playbook run instead of Path, when set.
+	playbook []byte
+	// rolesPath - the directories of the roles, added to the roles path of
+	// the runs.
+	rolesPath []string
+	// configDigest - digest of the configuration and files of the runs,
+	// computed once since they do not change while the operator runs.
+	configDigest string
+
+	watchDependentResources bool
+	kubernetesEvents        *bool
+	kubernetesDebugEvents   bool
 }
 
-func (r *runner) Run(u *unstructured.Unstructured, kubeconfig string) (chan eventapi.JobEvent, error) {
+// InputDirPath - returns the input directory of the runs for the resource
+// namespace/name of kind gvk, which also holds the artifacts of its last runs.
+// Cluster-scoped resources have an empty namespace.
+func InputDirPath(gvk schema.GroupVersionKind, namespace, name string) string {
+	return filepath.Join(InputDirRoot, gvk.Group, gvk.Version, gvk.Kind, namespace, name)
+}
+
+// Run - runs ansible-runner for the resource and returns the channel its
+// events are sent on. The channel is closed when ansible-runner exits. When
+// ctx is done before that, the whole ansible-runner process group is killed
+// and the inputs of the run are removed. The artifacts of the last runs are
+// kept in the input directory. c reads the Secrets and ConfigMaps of varsFrom
+// and envFrom; the values read from Secrets are redacted from the events and
+// artifacts, and the inputs holding them are removed after the run.
+func (r *runner) Run(ctx context.Context, u *unstructured.Unstructured, kubeconfig string, c client.Client) (chan eventapi.JobEvent, error) {
 	if u.GetDeletionTimestamp() != nil && !r.isFinalizerRun(u) {
 		return nil, errors.New("resource has been deleted, but no finalizer was matched, skipping reconciliation")
 	}
+	values, err := r.resolveValues(ctx, c, u)
+	if err != nil {
+		return nil, err
+	}
+	parameters := r.makeParameters(u)
+	for k, v := range values.vars {
+		parameters[k] = v
+	}
+	envVars := map[string]string{
+		AnsibleRolesPathEnvVar:        r.ansibleRolesPath(),
+		AnsibleCollectionsPathsEnvVar: ansiblePath(AnsibleCollectionsPathsEnvVar, filepath.Join(os.Getenv("HOME"), ".ansible", "collections"), "/usr/share/ansible/collections"),
+	}
+	for k, v := range values.envVars {
+		envVars[k] = v
+	}
+	envVars["K8S_AUTH_KUBECONFIG"] = kubeconfig
+	redact := newRedactor(values.secrets)
+
 	ident := strconv.Itoa(rand.Int())
 	logger := logrus.WithFields(logrus.Fields{
 		"component": "runner",
@@ -194,28 +564,34 @@ func (r *runner) Run(u *unstructured.Unstructured, kubeconfig string) (chan even
 	if err != nil {
 		return nil, err
 	}
+	receiver.DroppedEvents = metrics.DroppedEvents.WithLabelValues(metrics.GVKLabel(r.GVK))
+	receiver.Filter = r.eventTypes.AllowsEvent
 	inputDir := inputdir.InputDir{
-		Path:       filepath.Join("/tmp/ansible-operator/runner/", r.GVK.Group, r.GVK.Version, r.GVK.Kind, u.GetNamespace(), u.GetName()),
-		Parameters: r.makeParameters(u),
-		EnvVars: map[string]string{
-			"K8S_AUTH_KUBECONFIG": kubeconfig,
-		},
+		Path:       InputDirPath(r.GVK, u.GetNamespace(), u.GetName()),
+		Parameters: parameters,
+		EnvVars:    envVars,
 		Settings: map[string]string{
 			"runner_http_url":  receiver.SocketPath,
 			"runner_http_path": receiver.URLPath,
 		},
 	}
-	// If Path is a dir, assume it is a role path. Otherwise assume it's a
-	// playbook path
-	fi, err := os.Lstat(r.Path)
-	if err != nil {
-		return nil, err
-	}
-	if !fi.IsDir() {
-		inputDir.PlaybookPath = r.Path
+	if r.playbook != nil {
+		inputDir.Playbook = r.playbook
+	} else {
+		// If Path is a dir, assume it is a role path. Otherwise assume it's
+		// a playbook path
+		fi, err := os.Lstat(r.Path)
+		if err != nil {
+			receiver.Close()
+			return nil, err
+		}
+		if !fi.IsDir() {
+			inputDir.PlaybookPath = r.Path
+		}
 	}
 	err = inputDir.Write()
 	if err != nil {
+		receiver.Close()
 		return nil, err
 	}
 
@@ -228,13 +604,51 @@ func (r *runner) Run(u *unstructured.Unstructured, kubeconfig string) (chan even
 			dc = r.cmdFunc(ident, inputDir.Path)
 		}
 
-		err := dc.Run()
+		// Run ansible-runner in its own process group, so that the ansible
+		// processes it forks are killed along with it.
+		dc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		err := dc.Start()
+		if err == nil {
+			exited := make(chan error, 1)
+			go func() {
+				exited <- dc.Wait()
+			}()
+			select {
+			case err = <-exited:
+			case <-ctx.Done():
+				logger.Errorf("killing ansible-runner: %s", ctx.Err().Error())
+				if killErr := syscall.Kill(-dc.Process.Pid, syscall.SIGKILL); killErr != nil {
+					logger.Errorf("error killing ansible-runner: %s", killErr.Error())
+				}
+				err = <-exited
+			}
+		}
 		if err != nil {
 			logger.Errorf("error from ansible-runner: %s", err.Error())
 		} else {
 			logger.Info("ansible-runner exited successfully")
 		}
 
+		if saveErr := saveExtraVars(inputDir, ident); saveErr != nil {
+			logger.Errorf("error saving extravars: %s", saveErr.Error())
+		}
+		if redact != nil {
+			if redactErr := artifacts.Rewrite(artifacts.Dir(inputDir.Path, ident), redact.Bytes); redactErr != nil {
+				logger.Errorf("error redacting artifacts: %s", redactErr.Error())
+			}
+		}
+		if ctx.Err() != nil || redact != nil {
+			// Remove the inputs of runs that did not finish or that hold
+			// values of Secrets. Keep the artifacts of the run, they are
+			// needed to find out why it did not finish.
+			if rmErr := inputDir.Clean(); rmErr != nil {
+				logger.Errorf("error removing input directory: %s", rmErr.Error())
+			}
+		}
+		if pruneErr := artifacts.Prune(inputDir.Path, r.maxArtifacts); pruneErr != nil {
+			logger.Errorf("error removing old artifacts: %s", pruneErr.Error())
+		}
+
 		receiver.Close()
 		err = <-errChan
 		// http.Server returns this in the case of being closed cleanly
@@ -242,7 +656,38 @@ func (r *runner) Run(u *unstructured.Unstructured, kubeconfig string) (chan even
 			logger.Errorf("error from event api: %s", err.Error())
 		}
 	}()
-	return receiver.Events, nil
+	if redact == nil {
+		return receiver.Events, nil
+	}
+	events := make(chan eventapi.JobEvent)
+	go func() {
+		for e := range receiver.Events {
+			events <- redact.Event(e)
+		}
+		close(events)
+	}()
+	return events, nil
+}
+
+// ansibleRolesPath - the roles path of the runs: the directories of the roles
+// of the watch, then the roles path of the operator.
+func (r *runner) ansibleRolesPath() string {
+	dirs := append([]string{}, r.rolesPath...)
+	if r.playbook == nil {
+		if fi, err := os.Stat(r.Path); err == nil && fi.IsDir() {
+			dirs = append(dirs, filepath.Dir(r.Path))
+		}
+	}
+	return strings.Join(append(dirs, ansiblePath(AnsibleRolesPathEnvVar, filepath.Join(os.Getenv("HOME"), "roles"))), ":")
+}
+
+// ansiblePath - the value of the path list envVar for the operator, defaults
+// when it is not set.
+func ansiblePath(envVar string, defaults ...string) string {
+	if v, ok := os.LookupEnv(envVar); ok && v != "" {
+		return v
+	}
+	return strings.Join(defaults, ":")
 }
 
 // GetReconcilePeriod - new reconcile period.
@@ -253,6 +698,143 @@ func (r *runner) GetReconcilePeriod() (time.Duration, bool) {
 	return r.reconcilePeriod, true
 }
 
+// saveExtraVars - writes the extravars of the run ident next to its
+// artifacts, since the input directory is overwritten by the next run.
+func saveExtraVars(inputDir inputdir.InputDir, ident string) error {
+	b, err := json.Marshal(inputDir.Parameters)
+	if err != nil {
+		return err
+	}
+	dir := artifacts.Dir(inputDir.Path, ident)
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, artifacts.ExtraVarsFile), b, 0644)
+}
+
+// GetTimeout - maximum duration of a single run of ansible-runner.
+func (r *runner) GetTimeout() (time.Duration, bool) {
+	if r.timeout == time.Duration(0) {
+		return r.timeout, false
+	}
+	return r.timeout, true
+}
+
+// GetMaxWorkers - number of resources of the GVK that can be reconciled, and
+// so of ansible-runner processes that can run, at the same time.
+func (r *runner) GetMaxWorkers() (int, bool) {
+	if r.maxWorkers == 0 {
+		return r.maxWorkers, false
+	}
+	return r.maxWorkers, true
+}
+
+// GetWatchDependentResources - whether the resources created by the runs
+// should be watched, so that changes to them reconcile their owner.
+func (r *runner) GetWatchDependentResources() bool {
+	return r.watchDependentResources
+}
+
+// GetKubernetesEvents - whether the runs should be recorded as Kubernetes
+// Events on the resource, if set in the watches file.
+func (r *runner) GetKubernetesEvents() (bool, bool) {
+	if r.kubernetesEvents == nil {
+		return false, false
+	}
+	return *r.kubernetesEvents, true
+}
+
+// GetKubernetesDebugEvents - whether the debug messages of the runs should be
+// recorded as Kubernetes Events as well.
+func (r *runner) GetKubernetesDebugEvents() bool {
+	return r.kubernetesDebugEvents
+}
+
+// GetSelector - the label selector of the CRs that should be reconciled.
+func (r *runner) GetSelector() labels.Selector {
+	if r.selector == nil {
+		return labels.Everything()
+	}
+	return r.selector
+}
+
+// GetAccessRules - the kinds and verbs the runs may access through the proxy,
+// every one of them when empty.
+func (r *runner) GetAccessRules() []AccessRule {
+	return r.accessRules
+}
+
+// GetSkipUnchanged - whether runs should be skipped when the resource did not
+// change since the last successful run.
+func (r *runner) GetSkipUnchanged() bool {
+	return r.skipUnchanged
+}
+
+// GetParametersHash - returns a hash of the extravars passed to ansible for
+// the resource, of the versions of the Secrets and ConfigMaps read by c for
+// varsFrom and envFrom, and of the configuration and files of the runs. The
+// raw object is left out, since its metadata and status change without the
+// spec changing.
+func (r *runner) GetParametersHash(ctx context.Context, u *unstructured.Unstructured, c client.Client) (string, error) {
+	parameters := r.makeParameters(u)
+	delete(parameters, r.objectKey())
+	delete(parameters, r.objectKey()+"_previous")
+	delete(parameters, r.objectKey()+"_changed_paths")
+	values, err := r.resolveValues(ctx, c, u)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"parameters": parameters,
+		"values":     values.versions,
+		"config":     r.configDigest,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// digestConfig - returns a digest of the configuration of the runs and of the
+// contents of the playbook or role and of the roles path, so that runs are not
+// skipped after the operator is upgraded.
+func (r *runner) digestConfig() string {
+	h := sha256.New()
+	b, err := json.Marshal(map[string]interface{}{
+		"path":          r.Path,
+		"playbook":      string(r.playbook),
+		"rolesPath":     r.rolesPath,
+		"keyConversion": r.keyConversion,
+		"varsFrom":      r.varsFrom,
+		"envFrom":       r.envFrom,
+		"objectFields":  r.objectFields,
+	})
+	if err == nil {
+		h.Write(b)
+	}
+	for _, root := range append([]string{r.Path}, r.rolesPath...) {
+		if root == "" {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%v\x00%x\x00", path, sha256.Sum256(b))
+			return nil
+		})
+		if err != nil {
+			logrus.Warnf("Unable to read %v, its changes will not trigger new runs of %v: %v", root, r.GVK, err)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func (r *runner) GetFinalizer() (string, bool) {
 	if r.Finalizer != nil {
 		return r.Finalizer.Name, true
@@ -260,6 +842,20 @@ func (r *runner) GetFinalizer() (string, bool) {
 	return "", false
 }
 
+// GetFinalizerTimeout - maximum duration of a finalizer run, when it differs
+// from the timeout of the other runs.
+func (r *runner) GetFinalizerTimeout() (time.Duration, bool) {
+	if r.finalizerTimeout == time.Duration(0) {
+		return r.finalizerTimeout, false
+	}
+	return r.finalizerTimeout, true
+}
+
+// GetFinalizerRetry - how failed finalizer runs are retried.
+func (r *runner) GetFinalizerRetry() FinalizerRetry {
+	return r.finalizerRetry
+}
+
 func (r *runner) isFinalizerRun(u *unstructured.Unstructured) bool {
 	finalizersSet := r.Finalizer != nil && u.GetFinalizers() != nil
 	// The resource is deleted and our finalizer is present, we need to run the finalizer
@@ -275,9 +871,43 @@ func (r *runner) isFinalizerRun(u *unstructured.Unstructured) bool {
 
 func (r *runner) addFinalizer(finalizer *Finalizer) error {
 	r.Finalizer = finalizer
-	switch {
-	case finalizer == nil:
+	if finalizer == nil {
 		return nil
+	}
+	retry := FinalizerRetry{
+		Backoff:     DefaultFinalizerBackoff,
+		MaxBackoff:  DefaultFinalizerMaxBackoff,
+		MaxAttempts: finalizer.MaxAttempts,
+	}
+	for _, d := range []struct {
+		field string
+		value string
+		dest  *time.Duration
+	}{
+		{"timeout", finalizer.Timeout, &r.finalizerTimeout},
+		{"backoff", finalizer.Backoff, &retry.Backoff},
+		{"maxBackoff", finalizer.MaxBackoff, &retry.MaxBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("unable to parse finalizer %v: %v - %v", d.field, d.value, err)
+		}
+		if v < 0 {
+			return fmt.Errorf("finalizer %v must not be negative for %v", d.field, r.GVK)
+		}
+		*d.dest = v
+	}
+	if retry.MaxAttempts < 0 {
+		return fmt.Errorf("finalizer maxAttempts must not be negative for %v", r.GVK)
+	}
+	if retry.Backoff > retry.MaxBackoff {
+		return fmt.Errorf("finalizer backoff must not be greater than maxBackoff for %v", r.GVK)
+	}
+	r.finalizerRetry = retry
+	switch {
 	case finalizer.Playbook != "":
 		if !filepath.IsAbs(finalizer.Playbook) {
 			return fmt.Errorf("finalizer playbook path must be absolute for %v", r.GVK)
@@ -306,11 +936,17 @@ func (r *runner) addFinalizer(finalizer *Finalizer) error {
 //      "name": <object_name>,
 //      "namespace": <object_namespace>,
 //   },
-//   <cr_spec_fields_as_snake_case>,
+//   <cr_spec_fields_as_set_by_specKeyConversion>,
 //   ...
 //   _<group_as_snake>_<kind>: {
-//       <cr_object as is
+//       <cr_object as is, limited to the fields selected by objectFields
+//   }
+//   _<group_as_snake>_<kind>_previous: {
+//       <cr_spec of the last successful run as is, if any>
 //   }
+//   _<group_as_snake>_<kind>_changed_paths: [
+//       <dotted paths of the spec fields changed since then, if any>
+//   ]
 // }
 func (r *runner) makeParameters(u *unstructured.Unstructured) map[string]interface{} {
 	s := u.Object["spec"]
@@ -319,10 +955,13 @@ func (r *runner) makeParameters(u *unstructured.Unstructured) map[string]interfa
 		logrus.Warnf("spec was not found for CR:%v - %v in %v", u.GroupVersionKind(), u.GetNamespace(), u.GetName())
 		spec = map[string]interface{}{}
 	}
-	parameters := paramconv.MapToSnake(spec)
+	parameters := r.specParameters(spec)
 	parameters["meta"] = map[string]string{"namespace": u.GetNamespace(), "name": u.GetName()}
-	objectKey := fmt.Sprintf("_%v_%v", strings.Replace(r.GVK.Group, ".", "_", -1), strings.ToLower(r.GVK.Kind))
-	parameters[objectKey] = u.Object
+	parameters[r.objectKey()] = r.objectFields.object(u.Object)
+	if previous, ok, _ := unstructured.NestedMap(u.Object, "status", LastAppliedSpecField); ok {
+		parameters[r.objectKey()+"_previous"] = previous
+		parameters[r.objectKey()+"_changed_paths"] = changedPaths("", previous, spec)
+	}
 	if r.isFinalizerRun(u) {
 		for k, v := range r.Finalizer.Vars {
 			parameters[k] = v
@@ -330,3 +969,71 @@ func (r *runner) makeParameters(u *unstructured.Unstructured) map[string]interfa
 	}
 	return parameters
 }
+
+// specParameters - returns the variables for the spec, with its keys
+// converted as set by specKeyConversion.
+func (r *runner) specParameters(spec map[string]interface{}) map[string]interface{} {
+	converter := r.converter
+	if converter == nil {
+		converter = paramconv.NewConverter()
+	}
+	switch r.keyConversion {
+	case KeyConversionNone:
+		parameters := map[string]interface{}{}
+		for k, v := range spec {
+			parameters[k] = v
+		}
+		return parameters
+	case KeyConversionBoth:
+		parameters := converter.MapToSnake(spec)
+		for k, v := range spec {
+			if _, ok := parameters[k]; !ok {
+				parameters[k] = v
+			}
+		}
+		return parameters
+	default:
+		return converter.MapToSnake(spec)
+	}
+}
+
+// changedPaths - returns the dotted paths of the fields that differ between
+// the previous and current specs, sorted. Lists are compared as a whole.
+func changedPaths(prefix string, previous, current map[string]interface{}) []string {
+	paths := []string{}
+	keys := map[string]bool{}
+	for k := range previous {
+		keys[k] = true
+	}
+	for k := range current {
+		keys[k] = true
+	}
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		p, pok := previous[k].(map[string]interface{})
+		c, cok := current[k].(map[string]interface{})
+		if pok && cok {
+			paths = append(paths, changedPaths(path, p, c)...)
+			continue
+		}
+		// Values read from the API server may not share the same numeric
+		// types, so compare the serialized forms.
+		pb, _ := json.Marshal(previous[k])
+		cb, _ := json.Marshal(current[k])
+		_, inPrevious := previous[k]
+		_, inCurrent := current[k]
+		if inPrevious != inCurrent || string(pb) != string(cb) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// objectKey - the extravar holding the raw object, _<group_as_snake>_<kind>.
+func (r *runner) objectKey() string {
+	return fmt.Sprintf("_%v_%v", strings.Replace(r.GVK.Group, ".", "_", -1), strings.ToLower(r.GVK.Kind))
+}
@@ -15,14 +15,21 @@
 package runner
 
 import (
+	"context"
+	"fmt"
 	"html/template"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/artifacts"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 func TestNewFromWatches(t *testing.T) {
@@ -40,17 +47,25 @@ func TestNewFromWatches(t *testing.T) {
 		ValidRole:     filepath.Join(cwd, "testdata", "roles", "role"),
 	}
 
-	tmpl, err := template.ParseFiles("testdata/valid.yaml.tmpl")
-	if err != nil {
-	}
-	f, err := os.Create("testdata/valid.yaml")
-	if err != nil {
-		t.Fatalf("unable to create valid.yaml: %v", err)
+	for _, name := range []string{"valid.yaml", "invalid_storage_version.yaml", "invalid_finalizer_backoff.yaml"} {
+		tmpl, err := template.ParseFiles(filepath.Join("testdata", name+".tmpl"))
+		if err != nil {
+			t.Fatalf("unable to parse %v template: %v", name, err)
+		}
+		f, err := os.Create(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("unable to create %v: %v", name, err)
+		}
+		err = tmpl.Execute(f, validTemplate)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unable to create %v: %v", name, err)
+		}
 	}
-	err = tmpl.Execute(f, validTemplate)
+
+	tierNotTest, err := labels.NewRequirement("tier", selection.NotIn, []string{"test"})
 	if err != nil {
-		t.Fatalf("unable to create valid.yaml: %v", err)
-		return
+		t.Fatalf("unable to create requirement: %v", err)
 	}
 
 	testCases := []struct {
@@ -99,6 +114,61 @@ func TestNewFromWatches(t *testing.T) {
 			path:        "testdata/invalid_duration.yaml",
 			shouldError: true,
 		},
+		{
+			name:        "error invalid max workers",
+			path:        "testdata/invalid_max_workers.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid max runner artifacts",
+			path:        "testdata/invalid_max_runner_artifacts.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error no storage version",
+			path:        "testdata/invalid_storage_version.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid spec key conversion",
+			path:        "testdata/invalid_spec_key_conversion.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid values from",
+			path:        "testdata/invalid_values_from.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid object fields",
+			path:        "testdata/invalid_object_fields.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid finalizer backoff",
+			path:        "testdata/invalid_finalizer_backoff.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error role and roles",
+			path:        "testdata/invalid_roles.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid selector",
+			path:        "testdata/invalid_selector.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid allowed resources",
+			path:        "testdata/invalid_allowed_resources.yaml",
+			shouldError: true,
+		},
+		{
+			name:        "error invalid timeout",
+			path:        "testdata/invalid_timeout.yaml",
+			shouldError: true,
+		},
 		{
 			name: "valid watches file",
 			path: "testdata/valid.yaml",
@@ -115,6 +185,13 @@ func TestNewFromWatches(t *testing.T) {
 					},
 					Path:            validTemplate.ValidPlaybook,
 					reconcilePeriod: time.Second * 2,
+					skipUnchanged:   true,
+					maxWorkers:      2,
+					timeout:         time.Minute * 10,
+					maxArtifacts:    5,
+					keyConversion:   KeyConversionBoth,
+					selector:        labels.SelectorFromSet(labels.Set{"track": "canary"}).Add(*tierNotTest),
+					accessRules:     []AccessRule{{Group: "apps", Kind: "Deployment", Verbs: []string{"get", "create", "patch"}, Subresources: []string{"status"}}},
 				},
 				schema.GroupVersionKind{
 					Version: "v1alpha1",
@@ -126,13 +203,73 @@ func TestNewFromWatches(t *testing.T) {
 						Group:   "app.example.com",
 						Kind:    "Playbook",
 					},
-					Path: validTemplate.ValidPlaybook,
+					Path:             validTemplate.ValidPlaybook,
+					maxArtifacts:     DefaultMaxArtifacts,
+					keyConversion:    KeyConversionSnake,
+					finalizerTimeout: time.Minute,
+					finalizerRetry:   FinalizerRetry{Backoff: 10 * time.Second, MaxBackoff: 2 * time.Minute, MaxAttempts: 3},
 					Finalizer: &Finalizer{
 						Name: "finalizer.app.example.com",
 						Role: validTemplate.ValidRole,
 						Vars: map[string]interface{}{"sentinel": "finalizer_running"},
 					},
 				},
+				schema.GroupVersionKind{
+					Version: "v1alpha1",
+					Group:   "app.example.com",
+					Kind:    "Roles",
+				}: runner{
+					GVK: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "Roles",
+					},
+					Path:          validTemplate.ValidRole + ",example.collection.role",
+					maxArtifacts:  DefaultMaxArtifacts,
+					keyConversion: KeyConversionSnake,
+				},
+				schema.GroupVersionKind{
+					Version: "v1beta1",
+					Group:   "app.example.com",
+					Kind:    "MultiVersion",
+				}: runner{
+					GVK: schema.GroupVersionKind{
+						Version: "v1beta1",
+						Group:   "app.example.com",
+						Kind:    "MultiVersion",
+					},
+					Path:          validTemplate.ValidRole,
+					maxArtifacts:  DefaultMaxArtifacts,
+					keyConversion: KeyConversionSnake,
+				},
+				schema.GroupVersionKind{
+					Version: "v1alpha1",
+					Group:   "app.example.com",
+					Kind:    "MultiRole",
+				}: runner{
+					GVK: schema.GroupVersionKind{
+						Version: "v1alpha1",
+						Group:   "app.example.com",
+						Kind:    "MultiRole",
+					},
+					Path:          validTemplate.ValidPlaybook,
+					maxArtifacts:  DefaultMaxArtifacts,
+					keyConversion: KeyConversionSnake,
+				},
+				schema.GroupVersionKind{
+					Version: "v1beta1",
+					Group:   "app.example.com",
+					Kind:    "MultiRole",
+				}: runner{
+					GVK: schema.GroupVersionKind{
+						Version: "v1beta1",
+						Group:   "app.example.com",
+						Kind:    "MultiRole",
+					},
+					Path:          validTemplate.ValidRole,
+					maxArtifacts:  DefaultMaxArtifacts,
+					keyConversion: KeyConversionSnake,
+				},
 				schema.GroupVersionKind{
 					Version: "v1alpha1",
 					Group:   "app.example.com",
@@ -143,7 +280,10 @@ func TestNewFromWatches(t *testing.T) {
 						Group:   "app.example.com",
 						Kind:    "Role",
 					},
-					Path: validTemplate.ValidRole,
+					Path:           validTemplate.ValidRole,
+					maxArtifacts:   DefaultMaxArtifacts,
+					keyConversion:  KeyConversionSnake,
+					finalizerRetry: FinalizerRetry{Backoff: DefaultFinalizerBackoff, MaxBackoff: DefaultFinalizerMaxBackoff},
 					Finalizer: &Finalizer{
 						Name:     "finalizer.app.example.com",
 						Playbook: validTemplate.ValidPlaybook,
@@ -160,9 +300,15 @@ func TestNewFromWatches(t *testing.T) {
 			if err != nil && !tc.shouldError {
 				t.Fatalf("err: %v occurred unexpectedly", err)
 			}
+			if err == nil && tc.shouldError {
+				t.Fatalf("expected an error for %v", tc.path)
+			}
 			if err != nil && tc.shouldError {
 				return
 			}
+			if tc.expectedMap != nil && len(m) != len(tc.expectedMap) {
+				t.Fatalf("unexpected number of GVKs: %v expected: %v", len(m), len(tc.expectedMap))
+			}
 			for k, expectedR := range tc.expectedMap {
 				r, ok := m[k]
 				if !ok {
@@ -186,7 +332,277 @@ func TestNewFromWatches(t *testing.T) {
 				if run.reconcilePeriod != expectedR.reconcilePeriod {
 					t.Fatalf("the GVK: %v unexpected reconcile period: %v expected reconcile period: %v", k, run.reconcilePeriod, expectedR.reconcilePeriod)
 				}
+				if run.maxWorkers != expectedR.maxWorkers {
+					t.Fatalf("the GVK: %v unexpected max workers: %v expected max workers: %v", k, run.maxWorkers, expectedR.maxWorkers)
+				}
+				if run.maxArtifacts != expectedR.maxArtifacts {
+					t.Fatalf("the GVK: %v unexpected max artifacts: %v expected max artifacts: %v", k, run.maxArtifacts, expectedR.maxArtifacts)
+				}
+				if run.timeout != expectedR.timeout {
+					t.Fatalf("the GVK: %v unexpected timeout: %v expected timeout: %v", k, run.timeout, expectedR.timeout)
+				}
+				if run.finalizerTimeout != expectedR.finalizerTimeout {
+					t.Fatalf("the GVK: %v unexpected finalizer timeout: %v expected finalizer timeout: %v", k, run.finalizerTimeout, expectedR.finalizerTimeout)
+				}
+				if run.finalizerRetry != expectedR.finalizerRetry {
+					t.Fatalf("the GVK: %v unexpected finalizer retry: %#v expected finalizer retry: %#v", k, run.finalizerRetry, expectedR.finalizerRetry)
+				}
+				if run.keyConversion != expectedR.keyConversion {
+					t.Fatalf("the GVK: %v unexpected key conversion: %v expected key conversion: %v", k, run.keyConversion, expectedR.keyConversion)
+				}
+				if run.GetSelector().String() != expectedR.GetSelector().String() {
+					t.Fatalf("the GVK: %v unexpected selector: %v expected selector: %v", k, run.GetSelector(), expectedR.GetSelector())
+				}
+				if !reflect.DeepEqual(run.accessRules, expectedR.accessRules) {
+					t.Fatalf("the GVK: %v unexpected access rules: %#v expected access rules: %#v", k, run.accessRules, expectedR.accessRules)
+				}
+				if run.skipUnchanged != expectedR.skipUnchanged {
+					t.Fatalf("the GVK: %v unexpected skip unchanged: %v expected skip unchanged: %v", k, run.skipUnchanged, expectedR.skipUnchanged)
+				}
 			}
 		})
 	}
 }
+
+func TestGetParametersHash(t *testing.T) {
+	r := &runner{
+		GVK: schema.GroupVersionKind{
+			Version: "v1alpha1",
+			Group:   "app.example.com",
+			Kind:    "Playbook",
+		},
+	}
+	newObject := func(size int64, resourceVersion string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec":   map[string]interface{}{"size": size},
+			"status": map[string]interface{}{"observedGeneration": size},
+		}}
+		u.SetName("example")
+		u.SetNamespace("default")
+		u.SetResourceVersion(resourceVersion)
+		return u
+	}
+
+	h1, err := r.GetParametersHash(context.TODO(), newObject(1, "1"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h2, err := r.GetParametersHash(context.TODO(), newObject(1, "2"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 != h2 {
+		t.Fatalf("hash changed when only the metadata changed: %v != %v", h1, h2)
+	}
+	h3, err := r.GetParametersHash(context.TODO(), newObject(2, "3"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 == h3 {
+		t.Fatalf("hash did not change when the spec changed: %v", h1)
+	}
+
+	r.keyConversion = "none"
+	r.configDigest = r.digestConfig()
+	h4, err := r.GetParametersHash(context.TODO(), newObject(1, "1"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 == h4 {
+		t.Fatalf("hash did not change when the configuration changed: %v", h1)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unable to get working director: %v", err)
+	}
+	r := &runner{
+		Path: filepath.Join(cwd, "testdata", "playbook.yml"),
+		GVK: schema.GroupVersionKind{
+			Version: "v1alpha1",
+			Group:   "app.example.com",
+			Kind:    "Timeout",
+		},
+		cmdFunc: func(ident, inputDirPath string) *exec.Cmd {
+			return exec.Command("sleep", "60")
+		},
+		maxArtifacts: 1,
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+	u.SetName("example")
+	u.SetNamespace("default")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	events, err := r.Run(ctx, u, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatalf("unexpected event from a killed run")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("run was not killed when the context expired")
+	}
+	inputDirPath := filepath.Join(InputDirRoot, r.GVK.Group, r.GVK.Version, r.GVK.Kind, u.GetNamespace(), u.GetName())
+	defer os.RemoveAll(inputDirPath)
+	if _, err := os.Stat(filepath.Join(inputDirPath, "env")); !os.IsNotExist(err) {
+		t.Fatalf("inputs in %v were not removed: %v", inputDirPath, err)
+	}
+	runs, err := artifacts.List(inputDirPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("expected the artifacts of 1 run, got %v", len(runs))
+	}
+	if _, err := os.Stat(filepath.Join(artifacts.Dir(inputDirPath, runs[0].Ident), artifacts.ExtraVarsFile)); err != nil {
+		t.Fatalf("extravars of the run were not saved: %v", err)
+	}
+}
+
+func TestMakeParametersPrevious(t *testing.T) {
+	r := &runner{
+		GVK: schema.GroupVersionKind{
+			Version: "v1alpha1",
+			Group:   "app.example.com",
+			Kind:    "Database",
+		},
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"size":   int64(2),
+			"volume": map[string]interface{}{"capacity": "2Gi", "class": "fast"},
+			"users":  []interface{}{"admin"},
+		},
+	}}
+	parameters := r.makeParameters(u)
+	if _, ok := parameters["_app_example_com_database_previous"]; ok {
+		t.Fatalf("unexpected previous spec without a last applied spec")
+	}
+
+	u.Object["status"] = map[string]interface{}{
+		LastAppliedSpecField: map[string]interface{}{
+			"size":    float64(2),
+			"volume":  map[string]interface{}{"capacity": "1Gi", "class": "fast"},
+			"users":   []interface{}{"admin", "guest"},
+			"version": "1.0",
+		},
+	}
+	parameters = r.makeParameters(u)
+	if !reflect.DeepEqual(parameters["_app_example_com_database_previous"], u.Object["status"].(map[string]interface{})[LastAppliedSpecField]) {
+		t.Fatalf("unexpected previous spec: %#v", parameters["_app_example_com_database_previous"])
+	}
+	expectedPaths := []string{"users", "version", "volume.capacity"}
+	if !reflect.DeepEqual(parameters["_app_example_com_database_changed_paths"], expectedPaths) {
+		t.Fatalf("unexpected changed paths: %#v expected: %#v", parameters["_app_example_com_database_changed_paths"], expectedPaths)
+	}
+
+	h1, err := r.GetParametersHash(context.TODO(), u, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(u.Object, "status")
+	h2, err := r.GetParametersHash(context.TODO(), u, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 != h2 {
+		t.Fatalf("hash changed when only the previous spec changed: %v != %v", h1, h2)
+	}
+}
+
+func TestMakeParametersKeyConversion(t *testing.T) {
+	spec := map[string]interface{}{
+		"bucketARN":  "arn:aws:s3:::example",
+		"volumeSize": map[string]interface{}{"maxGi": int64(2)},
+	}
+	testCases := []struct {
+		name          string
+		keyConversion string
+		expected      map[string]interface{}
+	}{
+		{
+			name:          "snake",
+			keyConversion: KeyConversionSnake,
+			expected: map[string]interface{}{
+				"bucket_arn":  "arn:aws:s3:::example",
+				"volume_size": map[string]interface{}{"max_gi": int64(2)},
+			},
+		},
+		{
+			name:          "none",
+			keyConversion: KeyConversionNone,
+			expected:      spec,
+		},
+		{
+			name:          "both",
+			keyConversion: KeyConversionBoth,
+			expected: map[string]interface{}{
+				"bucket_arn":  "arn:aws:s3:::example",
+				"volume_size": map[string]interface{}{"max_gi": int64(2)},
+				"bucketARN":   "arn:aws:s3:::example",
+				"volumeSize":  map[string]interface{}{"maxGi": int64(2)},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &runner{keyConversion: tc.keyConversion}
+			parameters := r.specParameters(spec)
+			if !reflect.DeepEqual(parameters, tc.expected) {
+				t.Fatalf("unexpected parameters: %#v expected: %#v", parameters, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFinalizerRetryDelay(t *testing.T) {
+	retry := FinalizerRetry{Backoff: 5 * time.Second, MaxBackoff: time.Minute}
+	for attempts, expected := range map[int]time.Duration{
+		1:   5 * time.Second,
+		2:   10 * time.Second,
+		4:   40 * time.Second,
+		5:   time.Minute,
+		100: time.Minute,
+	} {
+		if delay := retry.Delay(attempts); delay != expected {
+			t.Fatalf("unexpected delay after %v attempts: %v expected: %v", attempts, delay, expected)
+		}
+	}
+}
+
+func TestNewForRoles(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unable to get working director: %v", err)
+	}
+	role := filepath.Join(cwd, "testdata", "roles", "role")
+	gvk := schema.GroupVersionKind{Version: "v1alpha1", Group: "app.example.com", Kind: "Roles"}
+	r, err := newForRoles([]string{role + "/", "example.collection.role"}, gvk, nil, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := fmt.Sprintf(`- gather_facts: false
+  hosts: localhost
+  roles:
+  - %v
+  - example.collection.role
+`, role)
+	if string(r.playbook) != expected {
+		t.Fatalf("unexpected playbook:\n%v\nexpected:\n%v", string(r.playbook), expected)
+	}
+	os.Setenv(AnsibleRolesPathEnvVar, "/opt/ansible/roles:/opt/ansible/galaxy")
+	defer os.Unsetenv(AnsibleRolesPathEnvVar)
+	if rolesPath := r.ansibleRolesPath(); rolesPath != filepath.Join(cwd, "testdata", "roles")+":/opt/ansible/roles:/opt/ansible/galaxy" {
+		t.Fatalf("unexpected roles path: %v", rolesPath)
+	}
+
+	if _, err := newForRoles([]string{"role"}, gvk, nil, 0); err == nil {
+		t.Fatalf("expected an error for a relative role path")
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RedactedValue - replaces the values read from Secrets in the events and
+// artifacts of the runs, as Ansible does for no_log values.
+const RedactedValue = "********"
+
+// ValuesFrom - a Secret or ConfigMap in the namespace of the CR whose data is
+// passed to Ansible, one variable per key. Exactly one of SecretRef and
+// ConfigMapRef must be set.
+type ValuesFrom struct {
+	SecretRef    *ValuesRef `yaml:"secretRef"`
+	ConfigMapRef *ValuesRef `yaml:"configMapRef"`
+}
+
+// ValuesRef - the name of a Secret or ConfigMap. Exactly one of Name and
+// NameFrom must be set.
+type ValuesRef struct {
+	Name string `yaml:"name"`
+	// NameFrom - dotted path of the field of the CR holding the name, such
+	// as spec.credentialsSecret.
+	NameFrom string `yaml:"nameFrom"`
+}
+
+// validate - returns an error when the source is not well formed.
+func (v ValuesFrom) validate() error {
+	ref := v.SecretRef
+	if (v.SecretRef == nil) == (v.ConfigMapRef == nil) {
+		return fmt.Errorf("exactly one of secretRef and configMapRef must be set")
+	}
+	if ref == nil {
+		ref = v.ConfigMapRef
+	}
+	if (ref.Name == "") == (ref.NameFrom == "") {
+		return fmt.Errorf("exactly one of name and nameFrom must be set")
+	}
+	return nil
+}
+
+// name - returns the name of the Secret or ConfigMap for u.
+func (r ValuesRef) name(u *unstructured.Unstructured) (string, error) {
+	if r.Name != "" {
+		return r.Name, nil
+	}
+	name, ok, err := unstructured.NestedString(u.Object, strings.Split(r.NameFrom, ".")...)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %v: %v", r.NameFrom, err)
+	}
+	if !ok || name == "" {
+		return "", fmt.Errorf("%v is not set", r.NameFrom)
+	}
+	return name, nil
+}
+
+// resolvedValues - the data of the sources of a run.
+type resolvedValues struct {
+	vars    map[string]interface{}
+	envVars map[string]string
+	// secrets - the values read from Secrets, hidden from the events and
+	// artifacts.
+	secrets []string
+	// versions - the kind, name and resourceVersion of each source, which
+	// change whenever its data changes.
+	versions []string
+}
+
+// resolveValues - reads the Secrets and ConfigMaps of varsFrom and envFrom
+// for u.
+func (r *runner) resolveValues(ctx context.Context, c client.Client, u *unstructured.Unstructured) (*resolvedValues, error) {
+	resolved := &resolvedValues{vars: map[string]interface{}{}, envVars: map[string]string{}}
+	if len(r.varsFrom) == 0 && len(r.envFrom) == 0 {
+		return resolved, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("no client to read the Secrets and ConfigMaps of %v", r.GVK)
+	}
+	read := func(v ValuesFrom, set func(k, v string)) error {
+		if v.SecretRef != nil {
+			name, err := v.SecretRef.name(u)
+			if err != nil {
+				return err
+			}
+			secret := &corev1.Secret{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: u.GetNamespace(), Name: name}, secret)
+			if err != nil {
+				return fmt.Errorf("unable to get Secret %v/%v: %v", u.GetNamespace(), name, err)
+			}
+			resolved.versions = append(resolved.versions, fmt.Sprintf("Secret/%v@%v", name, secret.GetResourceVersion()))
+			for k, v := range secret.Data {
+				set(k, string(v))
+				resolved.secrets = append(resolved.secrets, string(v))
+			}
+			return nil
+		}
+		name, err := v.ConfigMapRef.name(u)
+		if err != nil {
+			return err
+		}
+		configMap := &corev1.ConfigMap{}
+		err = c.Get(ctx, types.NamespacedName{Namespace: u.GetNamespace(), Name: name}, configMap)
+		if err != nil {
+			return fmt.Errorf("unable to get ConfigMap %v/%v: %v", u.GetNamespace(), name, err)
+		}
+		resolved.versions = append(resolved.versions, fmt.Sprintf("ConfigMap/%v@%v", name, configMap.GetResourceVersion()))
+		for k, v := range configMap.Data {
+			set(k, v)
+		}
+		return nil
+	}
+	for _, v := range r.varsFrom {
+		err := read(v, func(k, v string) { resolved.vars[k] = v })
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range r.envFrom {
+		err := read(v, func(k, v string) { resolved.envVars[k] = v })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// minRedactedLength - values shorter than this are only redacted where they
+// are a whole value, such as a string of the events or of a JSON document,
+// since replacing every occurrence of values like "1" or "true" would garble
+// the output.
+const minRedactedLength = 6
+
+// redactor - replaces values in the strings it is given with RedactedValue.
+type redactor struct {
+	replacer *strings.Replacer
+	values   map[string]bool
+}
+
+// newRedactor - returns a redactor for values, or nil when there is nothing
+// to redact.
+func newRedactor(values []string) *redactor {
+	oldnew := []string{}
+	whole := map[string]bool{}
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		whole[v] = true
+		// Values with quotes, backslashes or control characters are
+		// escaped in JSON documents.
+		escaped := v
+		b, err := json.Marshal(v)
+		if err == nil {
+			escaped = string(b[1 : len(b)-1])
+		}
+		if len(v) < minRedactedLength {
+			oldnew = append(oldnew, `"`+escaped+`"`, `"`+RedactedValue+`"`)
+			continue
+		}
+		oldnew = append(oldnew, v, RedactedValue)
+		if escaped != v {
+			oldnew = append(oldnew, escaped, RedactedValue)
+		}
+	}
+	if len(oldnew) == 0 {
+		return nil
+	}
+	return &redactor{replacer: strings.NewReplacer(oldnew...), values: whole}
+}
+
+// String - returns s with the values redacted.
+func (r *redactor) String(s string) string {
+	if r.values[s] {
+		return RedactedValue
+	}
+	return r.replacer.Replace(s)
+}
+
+// Bytes - returns b with the values redacted.
+func (r *redactor) Bytes(b []byte) []byte {
+	return []byte(r.replacer.Replace(string(b)))
+}
+
+// Event - returns e with the values redacted from its output and data.
+func (r *redactor) Event(e eventapi.JobEvent) eventapi.JobEvent {
+	e.StdOut = r.String(e.StdOut)
+	if e.EventData != nil {
+		e.EventData = r.value(e.EventData).(map[string]interface{})
+	}
+	return e
+}
+
+func (r *redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			redacted[k] = r.value(val)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, val := range v {
+			redacted[i] = r.value(val)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
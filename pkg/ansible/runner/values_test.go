@@ -0,0 +1,185 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResolveValues(t *testing.T) {
+	c := fake.NewFakeClient(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "default"},
+			Data:       map[string][]byte{"db_password": []byte("s3cr\"t")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-config", Namespace: "default"},
+			Data:       map[string]string{"DB_HOST": "db.example.com"},
+		},
+	)
+	r := &runner{
+		varsFrom: []ValuesFrom{{SecretRef: &ValuesRef{NameFrom: "spec.credentials"}}},
+		envFrom:  []ValuesFrom{{ConfigMapRef: &ValuesRef{Name: "db-config"}}},
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"credentials": "db-credentials"},
+	}}
+	u.SetName("example")
+	u.SetNamespace("default")
+
+	values, err := r.resolveValues(context.TODO(), c, u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(values.vars, map[string]interface{}{"db_password": "s3cr\"t"}) {
+		t.Fatalf("unexpected vars: %#v", values.vars)
+	}
+	if !reflect.DeepEqual(values.envVars, map[string]string{"DB_HOST": "db.example.com"}) {
+		t.Fatalf("unexpected env vars: %#v", values.envVars)
+	}
+	if !reflect.DeepEqual(values.secrets, []string{"s3cr\"t"}) {
+		t.Fatalf("unexpected secrets: %#v", values.secrets)
+	}
+
+	redact := newRedactor(values.secrets)
+	e := redact.Event(eventapi.JobEvent{
+		StdOut: "password is s3cr\"t",
+		EventData: map[string]interface{}{
+			"res": map[string]interface{}{"msg": []interface{}{"s3cr\"t"}},
+		},
+	})
+	if e.StdOut != "password is "+RedactedValue {
+		t.Fatalf("unexpected stdout: %v", e.StdOut)
+	}
+	if msg := e.EventData["res"].(map[string]interface{})["msg"]; !reflect.DeepEqual(msg, []interface{}{RedactedValue}) {
+		t.Fatalf("unexpected msg: %#v", msg)
+	}
+	if b := string(redact.Bytes([]byte(`{"db_password": "s3cr\"t"}`))); b != `{"db_password": "`+RedactedValue+`"}` {
+		t.Fatalf("unexpected redacted JSON: %v", b)
+	}
+
+	delete(u.Object, "spec")
+	if _, err := r.resolveValues(context.TODO(), c, u); err == nil {
+		t.Fatalf("expected an error when the field holding the name is not set")
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	redact := newRedactor([]string{"1", "true", "a", "s3cr3t-token", ""})
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "long value",
+			value:    "token is s3cr3t-token",
+			expected: "token is " + RedactedValue,
+		},
+		{
+			name:     "short whole value",
+			value:    "true",
+			expected: RedactedValue,
+		},
+		{
+			name:     "short values inside of a string",
+			value:    "changed: [localhost] => (item=1) a true story",
+			expected: "changed: [localhost] => (item=1) a true story",
+		},
+		{
+			name:     "short value in a JSON document",
+			value:    `{"replicas": 1, "password": "1", "debug": true, "name": "a-1"}`,
+			expected: `{"replicas": 1, "password": "` + RedactedValue + `", "debug": true, "name": "a-1"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if s := redact.String(tc.value); s != tc.expected {
+				t.Fatalf("unexpected redacted string: %v expected: %v", s, tc.expected)
+			}
+		})
+	}
+
+	e := redact.Event(eventapi.JobEvent{
+		StdOut: "ok: [localhost] => 1 item",
+		EventData: map[string]interface{}{
+			"task": "set a",
+			"res":  map[string]interface{}{"enabled": "true", "replicas": 1},
+		},
+	})
+	if e.StdOut != "ok: [localhost] => 1 item" || e.EventData["task"] != "set a" {
+		t.Fatalf("unexpected redacted event: %#v", e)
+	}
+	if res := e.EventData["res"]; !reflect.DeepEqual(res, map[string]interface{}{"enabled": RedactedValue, "replicas": 1}) {
+		t.Fatalf("unexpected res: %#v", res)
+	}
+
+	if newRedactor([]string{""}) != nil {
+		t.Fatalf("expected no redactor without values")
+	}
+}
+
+func TestGetParametersHashValues(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-config", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string]string{"DB_HOST": "db.example.com"},
+	}
+	c := fake.NewFakeClient(configMap)
+	r := &runner{
+		envFrom: []ValuesFrom{{ConfigMapRef: &ValuesRef{Name: "db-config"}}},
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"size": 1},
+	}}
+	u.SetName("example")
+	u.SetNamespace("default")
+
+	h1, err := r.GetParametersHash(context.TODO(), u, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h2, err := r.GetParametersHash(context.TODO(), u, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 != h2 {
+		t.Fatalf("hash changed when nothing changed: %v != %v", h1, h2)
+	}
+
+	configMap.Data["DB_HOST"] = "db2.example.com"
+	configMap.ResourceVersion = "2"
+	if err := c.Update(context.TODO(), configMap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h3, err := r.GetParametersHash(context.TODO(), u, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h1 == h3 {
+		t.Fatalf("hash did not change when the ConfigMap changed: %v", h1)
+	}
+
+	if _, err := r.GetParametersHash(context.TODO(), u, fake.NewFakeClient()); err == nil {
+		t.Fatalf("expected an error when the ConfigMap is missing")
+	}
+}
@@ -37,10 +37,33 @@ func (d *Dockerfile) GetInput() (input.Input, error) {
 	return d.Input, nil
 }
 
+// RequirementsYamlFile - the roles and collections installed in the operator
+// image with ansible-galaxy, when the project has one.
+const RequirementsYamlFile = "requirements.yml"
+
+// RequirementsInstallInstructions - the Dockerfile instructions installing the
+// roles and collections of requirements.yml, when the project has one. The
+// pattern copies requirements.yml only when it exists. The roles are installed
+// from a list, which every version of ansible-galaxy reads, and only the
+// collections require Ansible 2.9.
+const RequirementsInstallInstructions = `COPY watches.yaml requirements.y[m]l ${HOME}/
+RUN if [ -f ${HOME}/requirements.yml ]; then \
+      python -c 'import sys, yaml; r = yaml.safe_load(open(sys.argv[1])) or {}; r = r if isinstance(r, dict) else {"roles": r}; [yaml.safe_dump(r.get(k) or [], open("/tmp/requirements-" + k + ".yml", "w")) for k in ("roles", "collections")]' ${HOME}/requirements.yml \
+      && if [ "$(cat /tmp/requirements-roles.yml)" != "[]" ]; then \
+        ansible-galaxy install -r /tmp/requirements-roles.yml -p ${HOME}/roles; \
+      fi \
+      && if [ "$(cat /tmp/requirements-collections.yml)" != "[]" ]; then \
+        ansible-galaxy collection --help > /dev/null 2>&1 \
+          || { echo "installing the collections of requirements.yml requires Ansible 2.9 or later" >&2; exit 1; }; \
+        ansible-galaxy collection install -r ${HOME}/requirements.yml -p ${HOME}/.ansible/collections; \
+      fi \
+      && rm /tmp/requirements-*.yml; \
+    fi
+`
+
 const dockerFileAnsibleTmpl = `FROM quay.io/water-hole/ansible-operator
 
-COPY roles/ ${HOME}/roles/
+` + RequirementsInstallInstructions + `COPY roles/ ${HOME}/roles/
 {{- if .GeneratePlaybook }}
 COPY playbook.yaml ${HOME}/playbook.yaml{{ end }}
-COPY watches.yaml ${HOME}/watches.yaml
 `
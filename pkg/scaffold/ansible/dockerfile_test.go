@@ -0,0 +1,87 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ansible
+
+import (
+	"testing"
+)
+
+func TestDockerfile(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    *Dockerfile
+		expected string
+	}{
+		{
+			name:     "role",
+			input:    &Dockerfile{},
+			expected: dockerfileRoleExp,
+		},
+		{
+			name:     "playbook",
+			input:    &Dockerfile{GeneratePlaybook: true},
+			expected: dockerfilePlaybookExp,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, buf := setupScaffoldAndWriter()
+			err := s.Execute(appConfig, tc.input)
+			if err != nil {
+				t.Fatalf("failed to execute the scaffold: (%v)", err)
+			}
+			if tc.expected != buf.String() {
+				t.Fatalf("expected:\n%v\nactual:\n%v", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+const dockerfileRoleExp = `FROM quay.io/water-hole/ansible-operator
+
+COPY watches.yaml requirements.y[m]l ${HOME}/
+RUN if [ -f ${HOME}/requirements.yml ]; then \
+      python -c 'import sys, yaml; r = yaml.safe_load(open(sys.argv[1])) or {}; r = r if isinstance(r, dict) else {"roles": r}; [yaml.safe_dump(r.get(k) or [], open("/tmp/requirements-" + k + ".yml", "w")) for k in ("roles", "collections")]' ${HOME}/requirements.yml \
+      && if [ "$(cat /tmp/requirements-roles.yml)" != "[]" ]; then \
+        ansible-galaxy install -r /tmp/requirements-roles.yml -p ${HOME}/roles; \
+      fi \
+      && if [ "$(cat /tmp/requirements-collections.yml)" != "[]" ]; then \
+        ansible-galaxy collection --help > /dev/null 2>&1 \
+          || { echo "installing the collections of requirements.yml requires Ansible 2.9 or later" >&2; exit 1; }; \
+        ansible-galaxy collection install -r ${HOME}/requirements.yml -p ${HOME}/.ansible/collections; \
+      fi \
+      && rm /tmp/requirements-*.yml; \
+    fi
+COPY roles/ ${HOME}/roles/
+`
+
+const dockerfilePlaybookExp = `FROM quay.io/water-hole/ansible-operator
+
+COPY watches.yaml requirements.y[m]l ${HOME}/
+RUN if [ -f ${HOME}/requirements.yml ]; then \
+      python -c 'import sys, yaml; r = yaml.safe_load(open(sys.argv[1])) or {}; r = r if isinstance(r, dict) else {"roles": r}; [yaml.safe_dump(r.get(k) or [], open("/tmp/requirements-" + k + ".yml", "w")) for k in ("roles", "collections")]' ${HOME}/requirements.yml \
+      && if [ "$(cat /tmp/requirements-roles.yml)" != "[]" ]; then \
+        ansible-galaxy install -r /tmp/requirements-roles.yml -p ${HOME}/roles; \
+      fi \
+      && if [ "$(cat /tmp/requirements-collections.yml)" != "[]" ]; then \
+        ansible-galaxy collection --help > /dev/null 2>&1 \
+          || { echo "installing the collections of requirements.yml requires Ansible 2.9 or later" >&2; exit 1; }; \
+        ansible-galaxy collection install -r ${HOME}/requirements.yml -p ${HOME}/.ansible/collections; \
+      fi \
+      && rm /tmp/requirements-*.yml; \
+    fi
+COPY roles/ ${HOME}/roles/
+COPY playbook.yaml ${HOME}/playbook.yaml
+`
@@ -28,6 +28,13 @@ type Crd struct {
 
 	// Resource defines the inputs for the new custom resource definition
 	Resource *Resource
+
+	// StatusSubresource enables the status subresource of the custom resource
+	StatusSubresource bool
+
+	// ServedVersions are the versions served next to Resource.Version, which
+	// is the storage version, e.g. v1alpha1 when moving to v1beta1
+	ServedVersions []string
 }
 
 func (s *Crd) GetInput() (input.Input, error) {
@@ -38,6 +45,14 @@ func (s *Crd) GetInput() (input.Input, error) {
 			s.Resource.LowerKind)
 		s.Path = filepath.Join(CrdsDir, fileName)
 	}
+	for _, v := range s.ServedVersions {
+		if !ResourceVersionRegexp.MatchString(v) {
+			return input.Input{}, fmt.Errorf("served version (%v) is invalid", v)
+		}
+		if v == s.Resource.Version {
+			return input.Input{}, fmt.Errorf("served version (%v) is already the storage version", v)
+		}
+	}
 	s.TemplateBody = crdTemplate
 	return s.Input, nil
 }
@@ -55,5 +70,20 @@ spec:
     plural: {{ .Resource.Resource }}
     singular: {{ .Resource.LowerKind }}
   scope: Namespaced
-  version: v1alpha1
+  version: {{ .Resource.Version }}
+{{- if .ServedVersions }}
+  versions:
+  - name: {{ .Resource.Version }}
+    served: true
+    storage: true
+{{- range .ServedVersions }}
+  - name: {{ . }}
+    served: true
+    storage: false
+{{- end }}
+{{- end }}
+{{- if .StatusSubresource }}
+  subresources:
+    status: {}
+{{- end }}
 `
@@ -35,6 +35,46 @@ func TestCRD(t *testing.T) {
 	}
 }
 
+func TestCRDStatusSubresource(t *testing.T) {
+	r, err := NewResource(appApiVersion, appKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, buf := setupScaffoldAndWriter()
+	err = s.Execute(appConfig, &Crd{Resource: r, StatusSubresource: true})
+	if err != nil {
+		t.Fatalf("failed to execute the scaffold: (%v)", err)
+	}
+
+	if crdStatusSubresourceExp != buf.String() {
+		diffs := diff(crdStatusSubresourceExp, buf.String())
+		t.Fatalf("expected vs actual differs.\n%v", diffs)
+	}
+}
+
+func TestCRDServedVersions(t *testing.T) {
+	r, err := NewResource("app.example.com/v1beta1", appKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, buf := setupScaffoldAndWriter()
+	err = s.Execute(appConfig, &Crd{Resource: r, ServedVersions: []string{"v1alpha1"}})
+	if err != nil {
+		t.Fatalf("failed to execute the scaffold: (%v)", err)
+	}
+
+	if crdServedVersionsExp != buf.String() {
+		diffs := diff(crdServedVersionsExp, buf.String())
+		t.Fatalf("expected vs actual differs.\n%v", diffs)
+	}
+
+	s, _ = setupScaffoldAndWriter()
+	err = s.Execute(appConfig, &Crd{Resource: r, ServedVersions: []string{"v1beta1"}})
+	if err == nil {
+		t.Fatal("expected an error for a served version equal to the storage version")
+	}
+}
+
 const crdExp = `apiVersion: apiextensions.k8s.io/v1beta1
 kind: CustomResourceDefinition
 metadata:
@@ -49,3 +89,42 @@ spec:
   scope: Namespaced
   version: v1alpha1
 `
+
+const crdStatusSubresourceExp = `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: appservices.app.example.com
+spec:
+  group: app.example.com
+  names:
+    kind: AppService
+    listKind: AppServiceList
+    plural: appservices
+    singular: appservice
+  scope: Namespaced
+  version: v1alpha1
+  subresources:
+    status: {}
+`
+
+const crdServedVersionsExp = `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: appservices.app.example.com
+spec:
+  group: app.example.com
+  names:
+    kind: AppService
+    listKind: AppServiceList
+    plural: appservices
+    singular: appservice
+  scope: Namespaced
+  version: v1beta1
+  versions:
+  - name: v1beta1
+    served: true
+    storage: true
+  - name: v1alpha1
+    served: true
+    storage: false
+`
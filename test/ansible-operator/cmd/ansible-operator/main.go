@@ -20,8 +20,14 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/operator-framework/operator-sdk/pkg/ansible/events"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/ansible/operator"
 	proxy "github.com/operator-framework/operator-sdk/pkg/ansible/proxy"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/controllermap"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/proxy/kubeconfig"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner"
+	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/artifacts"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -38,7 +44,19 @@ func printVersion() {
 }
 
 func main() {
+	maxWorkers := flag.Int("max-workers", operator.DefaultMaxWorkers(), "Default number of resources of each watched kind reconciled concurrently, unless maxWorkers is set in watches.yaml. Defaults to $"+operator.MaxWorkersEnvVar+" or 1")
+	kubernetesEvents := flag.Bool("kubernetes-events", false, "Record the failed tasks and the summary of the runs as Kubernetes Events on the watched resources, unless kubernetesEvents is set in watches.yaml")
+	restrictToOwnerNamespace := flag.Bool("restrict-to-owner-namespace", false, "Reject the requests of the Ansible runs for resources outside of the namespace of the resource being reconciled")
+	proxyPort := flag.Int("proxy-port", 0, "Port of the proxy the Ansible runs connect to, a free port is picked when it is 0")
+	proxySocket := flag.String("proxy-socket", "", "Path of a unix socket the proxy listens on instead of a port, for the Ansible runs whose clients dial unix sockets")
+	artifactsPort := flag.Int("artifacts-port", artifacts.DefaultPort, "Port the artifacts of the runs are served on, a free port is picked when it is 0")
+	logFormat := flag.String("log-format", string(events.TextFormat), "Format of the logs, either "+string(events.TextFormat)+" or "+string(events.JSONFormat))
 	flag.Parse()
+	formatter, err := events.NewLogFormatter(events.LogFormat(*logFormat))
+	if err != nil {
+		log.Fatal(err)
+	}
+	logrus.SetFormatter(formatter)
 	logf.SetLogger(logf.ZapLogger(false))
 
 	namespace, err := k8sutil.GetWatchNamespace()
@@ -54,20 +72,42 @@ func main() {
 	}
 
 	printVersion()
+	metrics.Expose(mgr.GetConfig())
 	done := make(chan error)
 
+	cMap := controllermap.NewControllerMap()
+	tokens := kubeconfig.NewTokens()
+
 	// start the proxy
-	err = proxy.Run(done, proxy.Options{
-		Address:    "localhost",
-		Port:       8888,
-		KubeConfig: mgr.GetConfig(),
+	proxyURL, err := proxy.Run(done, proxy.Options{
+		Address:        "localhost",
+		Port:           *proxyPort,
+		SocketPath:     *proxySocket,
+		KubeConfig:     mgr.GetConfig(),
+		ControllerMap:  cMap,
+		RESTMapper:     mgr.GetRESTMapper(),
+		Cache:          mgr.GetCache(),
+		WatchNamespace: namespace,
+		Tokens:         tokens,
+
+		RestrictToOwnerNamespace: *restrictToOwnerNamespace,
 	})
 	if err != nil {
 		logrus.Fatalf("error starting proxy: %v", err)
 	}
 
+	// serve the artifacts of the runs
+	err = artifacts.Serve(done, artifacts.Options{
+		Address: "localhost",
+		Port:    *artifactsPort,
+		Dir:     runner.InputDirRoot,
+	})
+	if err != nil {
+		logrus.Errorf("error serving runner artifacts: %v", err)
+	}
+
 	// start the operator
-	go operator.Run(done, mgr, "/opt/ansible/watches.yaml", time.Minute)
+	go operator.Run(done, mgr, "/opt/ansible/watches.yaml", time.Minute, *maxWorkers, *kubernetesEvents, cMap, tokens, proxyURL)
 
 	// wait for either to finish
 	err = <-done